@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// albumWindow is how long to wait after the latest item of a media group
+// before treating the album as complete. Telegram delivers album items as
+// separate updates that can arrive a second or so apart.
+const albumWindow = 2 * time.Second
+
+// albumCollector groups messages sharing a MediaGroupID and hands each
+// album to flush once no new item has arrived for window.
+type albumCollector struct {
+	mu       sync.Mutex
+	window   time.Duration
+	inflight *sync.WaitGroup
+	flush    func(ctx context.Context, messages []*tgbotapi.Message)
+	groups   map[string]*album
+}
+
+type album struct {
+	ctx      context.Context
+	messages []*tgbotapi.Message
+	timer    *time.Timer
+}
+
+// newAlbumCollector returns a collector that counts each pending album in
+// inflight until flush returns, so shutdown waits for albums still being
+// collected.
+func newAlbumCollector(window time.Duration, inflight *sync.WaitGroup, flush func(context.Context, []*tgbotapi.Message)) *albumCollector {
+	return &albumCollector{
+		window:   window,
+		inflight: inflight,
+		flush:    flush,
+		groups:   make(map[string]*album),
+	}
+}
+
+func (c *albumCollector) add(ctx context.Context, message *tgbotapi.Message) {
+	id := message.MediaGroupID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g := c.groups[id]
+	if g == nil {
+		c.inflight.Add(1)
+		g = &album{ctx: ctx}
+		g.timer = time.AfterFunc(c.window, func() { c.fire(id) })
+		c.groups[id] = g
+	} else {
+		g.timer.Reset(c.window)
+	}
+	g.messages = append(g.messages, message)
+}
+
+func (c *albumCollector) fire(id string) {
+	c.mu.Lock()
+	g := c.groups[id]
+	delete(c.groups, id)
+	c.mu.Unlock()
+
+	// A Reset racing with the first expiry fires twice; the second finds
+	// the album already taken.
+	if g == nil {
+		return
+	}
+	defer c.inflight.Done()
+
+	// Updates may arrive out of order; message IDs follow the album order.
+	sort.Slice(g.messages, func(i, j int) bool {
+		return g.messages[i].MessageID < g.messages[j].MessageID
+	})
+	c.flush(g.ctx, g.messages)
+}
+
+// handleAlbum converts the videos of an album one at a time, in order,
+// reporting progress in a single status message.
+func (a *app) handleAlbum(ctx context.Context, messages []*tgbotapi.Message) {
+	if len(messages) == 1 {
+		a.handleVideo(ctx, messages[0])
+		return
+	}
+
+	first := messages[0]
+	chatID := first.Chat.ID
+	lang := languageOf(first)
+	logger := requestLogger(chatID, "media_group_id", first.MediaGroupID)
+	logger.Info("Processing album", "videos", len(messages))
+
+	// Registering the album as a whole lets /cancel stop the remaining
+	// videos, not just the current one.
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	text := localize(lang, msgAlbumProgress, 1, len(messages))
+	status := a.sendStatus(ctx, chatID, text)
+	batch := newProgressReporter(logger, a.bot, chatID, status, text)
+
+	for i, message := range messages {
+		if ctx.Err() != nil {
+			logger.Info("Album cancelled", "remaining", len(messages)-i)
+			return
+		}
+		if i > 0 {
+			batch.Restart(localize(lang, msgAlbumProgress, i+1, len(messages)))
+		}
+		a.processVideo(ctx, message, batch)
+	}
+
+	batch.Restart(localize(lang, msgAlbumDone, len(messages)))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chatAllowed reports whether chatID may use the bot: any chat when
+// ALLOWED_CHAT_IDS is unset, otherwise only those listed and the admin.
+func (a *app) chatAllowed(chatID int64) bool {
+	return len(a.cfg.AllowedChatIDs) == 0 || a.isAdmin(chatID) || slices.Contains(a.cfg.AllowedChatIDs, chatID)
+}
+
+// rejectUpdate turns away an update from a chat that isn't allowed. Only
+// private chats are told why; groups are ignored so the bot stays quiet
+// in chats it was added to by mistake.
+func (a *app) rejectUpdate(ctx context.Context, update tgbotapi.Update) {
+	chat := update.FromChat()
+	slog.Info("Ignoring update from chat not in ALLOWED_CHAT_IDS", "chat_id", chat.ID, "update_id", update.UpdateID)
+
+	switch {
+	case update.CallbackQuery != nil:
+		a.bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, localize(update.CallbackQuery.From.LanguageCode, msgNotAuthorized)))
+	case update.Message != nil && chat.IsPrivate():
+		sendText(ctx, a.bot, chat.ID, localize(languageOf(update.Message), msgNotAuthorized), textError)
+	}
+}
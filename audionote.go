@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// hasAudio reports whether message carries a voice message or audio file.
+func hasAudio(message *tgbotapi.Message) bool {
+	return message.Voice != nil || message.Audio != nil
+}
+
+// audioFile returns the file ID and length of message's voice message or
+// audio file.
+func audioFile(message *tgbotapi.Message) (fileID string, duration time.Duration) {
+	switch {
+	case message.Voice != nil:
+		return message.Voice.FileID, time.Duration(message.Voice.Duration) * time.Second
+	case message.Audio != nil:
+		return message.Audio.FileID, time.Duration(message.Audio.Duration) * time.Second
+	}
+	return "", 0
+}
+
+// handleAudioNote renders a voice message or audio file as a note showing
+// its animated waveform. It only runs with AUDIO_NOTES enabled.
+func (a *app) handleAudioNote(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	fileID, duration := audioFile(message)
+	logger := requestLogger(chatID, "file_id", fileID)
+	ctx = contextWithLogger(ctx, logger)
+
+	file, ok := a.getFile(ctx, fileID, chatID, lang)
+	if !ok {
+		return
+	}
+
+	inputPath, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, "audio", a.cfg.OutputFormat.Ext)
+	if !a.download(ctx, file, inputPath, chatID, lang) {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	c := conversion{
+		chatID:    chatID,
+		lang:      lang,
+		videoSize: a.videoSize(chatID),
+		duration:  duration,
+		caption:   message.Caption,
+	}
+	opts := a.videoOptions(c)
+	opts.Waveform = true
+	opts.Length = expectedLength(duration, opts.MaxDuration)
+	if duration > opts.MaxDuration {
+		a.sendStatus(ctx, chatID, localize(lang, msgTrimmed, int(opts.MaxDuration.Seconds())))
+	}
+
+	cached := false
+	defer func() {
+		if !cached {
+			os.Remove(outputPath)
+		}
+	}()
+
+	processing, err := a.encodeToFit(ctx, c, inputPath, outputPath, opts)
+	if ctx.Err() != nil {
+		logger.Info("Processing cancelled")
+		return
+	}
+	if errors.Is(err, errFFmpegTimeout) {
+		a.reportTimeout(ctx, c, err)
+		return
+	}
+	if errors.Is(err, errOutputTooLarge) {
+		a.reportOutputTooLarge(ctx, c, err)
+		return
+	}
+	if err != nil {
+		logger.Error("Error rendering waveform", "err", err)
+		a.reportFFmpegFailure(ctx, c, err)
+		return
+	}
+
+	cached = a.deliver(ctx, c, outputPath, processing)
+}
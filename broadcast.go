@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// broadcastInterval keeps broadcasts under Telegram's limit of about 30
+// messages per second across all chats.
+const broadcastInterval = 40 * time.Millisecond
+
+// handleBroadcast sends the command's text to every known chat in the
+// background and reports the outcome to the admin.
+func (a *app) handleBroadcast(ctx context.Context, message *tgbotapi.Message) {
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" {
+		sendText(ctx, a.bot, message.Chat.ID, "Usage: /broadcast <message>", textError)
+		return
+	}
+
+	chats := a.chats.list()
+	sendText(ctx, a.bot, message.Chat.ID, fmt.Sprintf("Broadcasting to %d chats...", len(chats)), textProgress)
+
+	a.inflight.Add(1)
+	go func() {
+		defer a.inflight.Done()
+		defer a.recoverRequest(ctx, message.Chat.ID, languageOf(message))
+		sent, failed, pruned := a.broadcast(chats, text)
+		sendText(ctx, a.bot, message.Chat.ID,
+			fmt.Sprintf("Broadcast finished: %d sent, %d failed, %d unreachable chats removed.", sent, failed, pruned), textProgress)
+	}()
+}
+
+func (a *app) broadcast(chats []int64, text string) (sent, failed, pruned int) {
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+
+	for _, chatID := range chats {
+		<-ticker.C
+
+		_, err := a.bot.Send(tgbotapi.NewMessage(chatID, text))
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, errChatUnreachable):
+			slog.Info("Removing unreachable chat", "chat_id", chatID, "err", err)
+			if err := a.chats.remove(chatID); err != nil {
+				slog.Error("Error saving chat list", "err", err)
+			}
+			pruned++
+		default:
+			slog.Warn("Error sending broadcast", "chat_id", chatID, "err", err)
+			failed++
+		}
+	}
+
+	slog.Info("Broadcast finished", "sent", sent, "failed", failed, "pruned", pruned)
+	return sent, failed, pruned
+}
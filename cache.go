@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputCache is a bounded LRU of processed notes keyed by the source
+// file's unique ID and the settings used, so re-sent files skip ffmpeg. The
+// cache owns the files it holds and deletes them on eviction.
+type outputCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	path    string
+	expires time.Time
+}
+
+func newOutputCache(maxEntries int, ttl time.Duration) *outputCache {
+	return &outputCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached output path for key if it is fresh and the file
+// still exists.
+func (c *outputCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return "", false
+	}
+	if _, err := os.Stat(entry.path); err != nil {
+		c.remove(e)
+		return "", false
+	}
+
+	c.ll.MoveToFront(e)
+	return entry.path, true
+}
+
+// put hands path over to the cache. It reports false, leaving the caller
+// responsible for the file, when caching is disabled.
+func (c *outputCache) put(key, path string) bool {
+	if c.maxEntries <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, path: path, expires: time.Now().Add(c.ttl)})
+	for c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+	}
+	return true
+}
+
+// invalidate drops key, deleting its file.
+func (c *outputCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *outputCache) remove(e *list.Element) {
+	entry := c.ll.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	os.Remove(entry.path)
+}
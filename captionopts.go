@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidSizeOption    = errors.New("invalid size option")
+	errInvalidQualityOption = errors.New("invalid quality option")
+	errInvalidFitOption     = errors.New("invalid fit option")
+)
+
+// captionOptions are per-video overrides written as key=value words in a
+// caption, e.g. "size=512 quality=high from=0:10". They apply to that one
+// video on top of the chat's settings, which they never change. Zero
+// fields leave the setting alone. Only captions that are option strings
+// (see isOptionString) are parsed for them.
+type captionOptions struct {
+	size    int
+	quality string
+	fit     *bool
+	// start skips the beginning of the input; see parseClockTime.
+	start time.Duration
+	crop  cropPosition
+	// target is a chat to post the note to instead; see applyTarget.
+	target string
+	// applied lists the recognised options as written, to confirm them.
+	applied []string
+}
+
+// isOptionString reports whether caption consists only of key=value words,
+// the form option strings take. Anything else, like "from=Paris with love",
+// is an ordinary caption that happens to contain an '='.
+func isOptionString(caption string) bool {
+	fields := strings.Fields(caption)
+	for _, field := range fields {
+		if key, _, ok := strings.Cut(field, "="); !ok || key == "" {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+// parseCaptionOptions extracts the recognised options from caption and
+// returns them with the caption minus the options. Words with unknown keys
+// are left in the caption as ordinary text. A malformed value for a known
+// key fails with that key's error, such as errInvalidOffset for from=.
+func parseCaptionOptions(caption string) (captionOptions, string, error) {
+	var opts captionOptions
+	var rest []string
+	for _, field := range strings.Fields(caption) {
+		key, value, ok := strings.Cut(strings.ToLower(field), "=")
+		if !ok {
+			rest = append(rest, field)
+			continue
+		}
+
+		var err error
+		switch key {
+		case "size":
+			opts.size, err = strconv.Atoi(value)
+			if err != nil || !validVideoSize(opts.size) {
+				err = errInvalidSizeOption
+			}
+		case "quality":
+			opts.quality = value
+			if _, ok := qualityLevels[value]; !ok {
+				err = errInvalidQualityOption
+			}
+		case "fit":
+			if value != "on" && value != "off" {
+				err = errInvalidFitOption
+			}
+			fit := value == "on"
+			opts.fit = &fit
+		case "from":
+			opts.start, err = parseClockTime(value)
+		case "crop":
+			opts.crop, err = parseCropPosition(value)
+		case "target":
+			opts.target, err = parseTargetOption(value)
+		default:
+			rest = append(rest, field)
+			continue
+		}
+		if err != nil {
+			return captionOptions{}, caption, err
+		}
+		opts.applied = append(opts.applied, key+"="+value)
+	}
+
+	if len(opts.applied) == 0 {
+		return opts, caption, nil
+	}
+	return opts, strings.Join(rest, " "), nil
+}
+
+// captionOptionError returns the message explaining a parseCaptionOptions
+// error.
+func captionOptionError(lang string, err error) string {
+	switch {
+	case errors.Is(err, errInvalidSizeOption):
+		return localize(lang, msgInvalidSize, minVideoSize, maxVideoSize, videoSizeStep)
+	case errors.Is(err, errInvalidQualityOption):
+		return localize(lang, msgInvalidQualityOption)
+	case errors.Is(err, errInvalidFitOption):
+		return localize(lang, msgInvalidFitOption)
+	case errors.Is(err, errInvalidCrop):
+		return localize(lang, msgInvalidCrop)
+	case errors.Is(err, errInvalidTargetOption):
+		return localize(lang, msgInvalidTargetOption)
+	default:
+		return localize(lang, msgInvalidOffset)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsOptionString(t *testing.T) {
+	tests := []struct {
+		caption string
+		want    bool
+	}{
+		{"size=512", true},
+		{"size=512 quality=high from=0:10", true},
+		{"  fit=on  ", true},
+		{"size=big", true},
+		{"", false},
+		{"holiday", false},
+		{"from=Paris with love", false},
+		{"size=512 my holiday", false},
+		{"2+2=4 is maths", false},
+		{"=512", false},
+	}
+
+	for _, tt := range tests {
+		if got := isOptionString(tt.caption); got != tt.want {
+			t.Errorf("isOptionString(%q) = %t, want %t", tt.caption, got, tt.want)
+		}
+	}
+}
+
+// TestHandleVideoProseCaption checks that captions which merely contain a
+// recognised key, malformed or not, convert with the chat's settings.
+func TestHandleVideoProseCaption(t *testing.T) {
+	for _, caption := range []string{"from=Paris with love", "size=big day out", "crop=wheat quality=questionable, fit=for a king"} {
+		t.Run(caption, func(t *testing.T) {
+			tg := newFakeTelegram(t)
+			tg.files["video1"] = []byte("video")
+			fakeVideoProbe(t)
+
+			var gotOpts videoOptions
+			a := newTestApp(t, tg, func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(int)) error {
+				gotOpts = opts
+				return os.WriteFile(outputPath, []byte("note"), 0o644)
+			})
+
+			message := videoMessage(42, "video1", 5)
+			message.Caption = caption
+			a.handleVideo(context.Background(), message)
+
+			if len(tg.notes) != 1 {
+				t.Fatalf("sent %d notes, want 1; messages %q", len(tg.notes), tg.texts)
+			}
+			if gotOpts.Size != a.cfg.VideoSize || gotOpts.Start != 0 {
+				t.Errorf("transcode got size %d, start %v; want the defaults", gotOpts.Size, gotOpts.Start)
+			}
+		})
+	}
+}
+
+func TestHandleVideoOptionCaption(t *testing.T) {
+	tg := newFakeTelegram(t)
+	tg.files["video1"] = []byte("video")
+	fakeVideoProbe(t)
+
+	var gotOpts videoOptions
+	a := newTestApp(t, tg, func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(int)) error {
+		gotOpts = opts
+		return os.WriteFile(outputPath, []byte("note"), 0o644)
+	})
+
+	message := videoMessage(42, "video1", 5)
+	message.Caption = "size=384 from=0:01"
+	a.handleVideo(context.Background(), message)
+
+	if len(tg.notes) != 1 {
+		t.Fatalf("sent %d notes, want 1; messages %q", len(tg.notes), tg.texts)
+	}
+	if gotOpts.Size != 384 || gotOpts.Start != time.Second {
+		t.Errorf("transcode got size %d, start %v; want 384, 1s", gotOpts.Size, gotOpts.Start)
+	}
+
+	// An option string with a bad value is still rejected.
+	tg.notes = nil
+	message.Caption = "size=big"
+	a.handleVideo(context.Background(), message)
+	if len(tg.notes) != 0 {
+		t.Errorf("sent %d notes for an invalid size option, want none", len(tg.notes))
+	}
+	if want := captionOptionError("en", errInvalidSizeOption); !tg.sentText(want) {
+		t.Errorf("no %q among %q", want, tg.texts)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chatActionInterval re-sends a chat action before Telegram's five second
+// expiry so the indicator doesn't flicker off.
+const chatActionInterval = 4 * time.Second
+
+// showChatAction keeps action, such as "record_video_note", displayed in
+// chatID until the returned stop function is called or ctx is done. stop
+// waits for the refreshing goroutine to exit, so no action is sent after
+// it returns.
+func (a *app) showChatAction(ctx context.Context, chatID int64, action string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	logger := loggerFromContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(chatActionInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := a.bot.Request(tgbotapi.NewChatAction(chatID, action)); err != nil {
+				logger.Debug("Error sending chat action", "action", action, "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// chatSet is the persistent set of chats that have talked to the bot, used
+// as the audience for /broadcast. It is saved to path as a JSON array
+// whenever a chat is added or removed.
+type chatSet struct {
+	mu    sync.Mutex
+	path  string
+	chats map[int64]bool
+}
+
+// loadChatSet reads the set stored at path; a missing file is an empty set.
+func loadChatSet(path string) (*chatSet, error) {
+	s := &chatSet{path: path, chats: make(map[int64]bool)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.chats[id] = true
+	}
+	return s, nil
+}
+
+// add records chatID, saving the set if it wasn't known yet, and reports
+// whether it was new.
+func (s *chatSet) add(chatID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chats[chatID] {
+		return false, nil
+	}
+	s.chats[chatID] = true
+	return true, s.save()
+}
+
+func (s *chatSet) remove(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.chats[chatID] {
+		return nil
+	}
+	delete(s.chats, chatID)
+	return s.save()
+}
+
+// list returns the known chat IDs in ascending order.
+func (s *chatSet) list() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedIDs()
+}
+
+func (s *chatSet) sortedIDs() []int64 {
+	ids := make([]int64, 0, len(s.chats))
+	for id := range s.chats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// save writes the set through a temp file so a crash never leaves it
+// half-written. s.mu must be held.
+func (s *chatSet) save() error {
+	data, err := json.Marshal(s.sortedIDs())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces path with data via a temp file and rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
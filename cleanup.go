@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	inputFilePrefix  = "input_"
+	outputFilePrefix = "output_"
+
+	// staleTempFileAge is comfortably longer than any single conversion.
+	staleTempFileAge = time.Hour
+
+	// tempSweepInterval is how often the work directory is swept while
+	// running. Each wait is jittered by up to tempSweepJitter either way so
+	// several instances sharing a directory don't sweep in lockstep.
+	tempSweepInterval = 10 * time.Minute
+	tempSweepJitter   = 0.2
+
+	// maxFileNameLength caps the part of a temp file name taken from the
+	// upload, well below file system limits.
+	maxFileNameLength = 64
+)
+
+// prepareWorkDir creates dir if needed and checks that files can be
+// written to it.
+func prepareWorkDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// mimeExtensions maps video MIME types to the extension ffmpeg expects for
+// the container, for uploads whose name lacks one.
+var mimeExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"video/x-msvideo":  ".avi",
+	"video/mpeg":       ".mpg",
+	"video/3gpp":       ".3gp",
+	"image/gif":        ".gif",
+}
+
+// inputFileName returns a name for the input temp file that keeps the
+// upload's real extension, deriving one from mimeType (or falling back to
+// .mp4) when the name has none, so ffmpeg sees the right container.
+func inputFileName(fileName, mimeType string) string {
+	fileName = strings.TrimSuffix(sanitizeFileName(fileName), ".")
+	if fileName == "" {
+		fileName = "video"
+	}
+	if filepath.Ext(fileName) != "" {
+		return fileName
+	}
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return fileName + ext
+	}
+	return fileName + ".mp4"
+}
+
+// sanitizeFileName reduces an untrusted upload name to a bare file name
+// that is safe to join into a path: anything up to the last slash or
+// backslash is dropped, characters other than ASCII letters, digits, '.',
+// '-' and '_' become '_', leading dots are removed so the result can't be
+// "." or ".." or hidden, and long names are shortened keeping a short
+// extension. It may return "".
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	name = strings.TrimLeft(name, ".")
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameLength/4 {
+			ext = ""
+		}
+		name = name[:maxFileNameLength-len(ext)] + ext
+	}
+	return name
+}
+
+// formatExtensions lists, per ffprobe format name, the extensions that
+// belong to it; the first is used when the current one doesn't fit.
+var formatExtensions = map[string][]string{
+	"mov":      {".mp4", ".mov", ".m4v", ".3gp", ".3g2"},
+	"matroska": {".mkv", ".webm"},
+	"webm":     {".webm", ".mkv"},
+	"avi":      {".avi"},
+	"mpeg":     {".mpg", ".mpeg"},
+	"mpegts":   {".ts"},
+	"flv":      {".flv"},
+	"gif":      {".gif"},
+}
+
+// sniffedExtension picks the extension for content ffprobe identified as
+// formatName, keeping current if it already matches.
+func sniffedExtension(formatName, current string) string {
+	for _, name := range strings.Split(formatName, ",") {
+		exts, ok := formatExtensions[name]
+		if !ok {
+			continue
+		}
+		for _, ext := range exts {
+			if strings.EqualFold(ext, current) {
+				return current
+			}
+		}
+		return exts[0]
+	}
+	return current
+}
+
+// sniffInput probes the downloaded file at path and renames it so its
+// extension matches the content, returning the new path. It fails for
+// files ffprobe can't read as media.
+func sniffInput(ctx context.Context, path string) (string, error) {
+	formatName, err := probeFormat(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(path)
+	sniffed := sniffedExtension(formatName, ext)
+	if sniffed == ext {
+		return path, nil
+	}
+
+	renamed := strings.TrimSuffix(path, ext) + sniffed
+	if err := os.Rename(path, renamed); err != nil {
+		return "", err
+	}
+	loggerFromContext(ctx).Debug("Renamed input to match content", "format", formatName, "from", ext, "to", sniffed)
+	return renamed, nil
+}
+
+// tempFilePaths returns input and output paths in dir for one conversion.
+// Both carry the chat ID and a random component so concurrent uploads of
+// identically named files never collide, and the output gets outputExt.
+// fileName is sanitized, so both paths always stay directly inside dir.
+func tempFilePaths(dir string, chatID int64, fileName, outputExt string) (inputPath, outputPath string) {
+	fileName = sanitizeFileName(fileName)
+	id := make([]byte, 8)
+	rand.Read(id)
+	unique := fmt.Sprintf("%d_%s_", chatID, hex.EncodeToString(id))
+
+	outputName := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + outputExt
+	return filepath.Join(dir, inputFilePrefix+unique+fileName),
+		filepath.Join(dir, outputFilePrefix+unique+outputName)
+}
+
+// cleanupTempFiles removes leftover input/output files in dir older than
+// maxAge, e.g. from a conversion interrupted by a crash. It returns the
+// number of files removed.
+func cleanupTempFiles(dir string, maxAge time.Duration) int {
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+
+	for _, prefix := range []string{inputFilePrefix, outputFilePrefix} {
+		matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+		if err != nil {
+			slog.Error("Error listing temp files", "err", err)
+			continue
+		}
+
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				slog.Error("Error removing stale temp file", "path", path, "err", err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// sweepTempFiles removes temp files in dir older than maxAge every
+// tempSweepInterval, give or take the jitter, until ctx is done. It catches
+// files whose deferred removal never ran, e.g. after a recovered panic.
+func sweepTempFiles(ctx context.Context, dir string, maxAge time.Duration) {
+	timer := time.NewTimer(jitter(tempSweepInterval, tempSweepJitter))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if n := cleanupTempFiles(dir, maxAge); n > 0 {
+			slog.Info("Removed stale temp files", "count", n)
+		}
+		timer.Reset(jitter(tempSweepInterval, tempSweepJitter))
+	}
+}
+
+// jitter returns d shifted randomly by up to fraction of itself either way.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	return d + time.Duration((mathrand.Float64()*2-1)*fraction*float64(d))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInputFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		mimeType string
+		want     string
+	}{
+		{"mp4 kept", "clip.mp4", "video/mp4", "clip.mp4"},
+		{"webm kept", "clip.webm", "video/webm", "clip.webm"},
+		{"mkv kept", "clip.mkv", "video/x-matroska", "clip.mkv"},
+		{"extension wins over mime type", "clip.mkv", "video/mp4", "clip.mkv"},
+		{"no dot, known mime type", "clip", "video/webm", "clip.webm"},
+		{"no dot, unknown mime type", "clip", "application/octet-stream", "clip.mp4"},
+		{"no dot, no mime type", "clip", "", "clip.mp4"},
+		{"trailing dot", "clip.", "video/x-matroska", "clip.mkv"},
+		{"empty name", "", "video/quicktime", "video.mov"},
+		{"empty name, no mime type", "", "", "video.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inputFileName(tt.fileName, tt.mimeType); got != tt.want {
+				t.Errorf("inputFileName(%q, %q) = %q, want %q", tt.fileName, tt.mimeType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffedExtension(t *testing.T) {
+	tests := []struct {
+		name       string
+		formatName string
+		current    string
+		want       string
+	}{
+		{"matching extension kept", "mov,mp4,m4a,3gp,3g2,mj2", ".mov", ".mov"},
+		{"case-insensitive match", "mov,mp4,m4a,3gp,3g2,mj2", ".MP4", ".MP4"},
+		{"mismatched extension replaced", "matroska,webm", ".mp4", ".mkv"},
+		{"webm kept for matroska", "matroska,webm", ".webm", ".webm"},
+		{"missing extension added", "avi", "", ".avi"},
+		{"unknown format left alone", "wav", ".mp4", ".mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffedExtension(tt.formatName, tt.current); got != tt.want {
+				t.Errorf("sniffedExtension(%q, %q) = %q, want %q", tt.formatName, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		// format is what the fake ffprobe reports; "" makes it fail as it
+		// does for files that aren't media.
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty file name", fileName: "", format: "matroska,webm", want: "video.mkv"},
+		{name: "extensionless file name", fileName: "clip", format: "mov,mp4,m4a,3gp,3g2,mj2", want: "clip.mp4"},
+		{name: "mismatched extension", fileName: "clip.mp4", format: "matroska,webm", want: "clip.mkv"},
+		{name: "matching extension", fileName: "clip.webm", format: "matroska,webm", want: "clip.webm"},
+		{name: "not media", fileName: "notes.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.format == "" {
+				fakeFFprobe(t, "echo 'Invalid data found when processing input' >&2\nexit 1")
+			} else {
+				fakeFFprobe(t, "echo "+tt.format)
+			}
+
+			path := filepath.Join(dir, inputFileName(tt.fileName, ""))
+			if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sniffInput(context.Background(), path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("sniffInput() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sniffInput() error: %v", err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("sniffInput() = %q, want %q", got, want)
+			}
+			if _, err := os.Stat(got); err != nil {
+				t.Errorf("input not at the returned path: %v", err)
+			}
+		})
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain name", "clip.mp4", "clip.mp4"},
+		{"parent traversal", "../../etc/passwd", "passwd"},
+		{"mixed separators", `a/b\c.mp4`, "c.mp4"},
+		{"dot dot", "..", ""},
+		{"dot", ".", ""},
+		{"trailing slash", "videos/", ""},
+		{"hidden file", ".profile", "profile"},
+		{"empty", "", ""},
+		{"NUL byte", "clip\x00.mp4", "clip_.mp4"},
+		{"spaces and unicode", "my clip ü.mp4", "my_clip__.mp4"},
+		{"long name keeps extension", strings.Repeat("a", 200) + ".mp4", strings.Repeat("a", maxFileNameLength-4) + ".mp4"},
+		{"long extension dropped", "a." + strings.Repeat("x", 100), "a." + strings.Repeat("x", maxFileNameLength-2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFileName(tt.in); got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTempFilePathsStayInDir checks that hostile upload names can't move
+// the temp files out of the work directory.
+func TestTempFilePathsStayInDir(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"../../etc/passwd",
+		`a/b\c.mp4`,
+		`..\..\windows\system32\cmd.exe`,
+		"..",
+		"",
+		"/",
+		"clip\x00../../x.mp4",
+		"/absolute/path.mp4",
+		strings.Repeat("../", 100) + "x.mp4",
+		strings.Repeat("a", 10000) + ".mp4",
+	}
+
+	for _, name := range names {
+		input, output := tempFilePaths(dir, 42, name, ".mp4")
+		for _, path := range []string{input, output} {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				t.Fatalf("tempFilePaths(%q): %v", name, err)
+			}
+			if strings.HasPrefix(rel, "..") || filepath.Dir(path) != dir {
+				t.Errorf("tempFilePaths(%q) = %q, not directly inside %q", name, path, dir)
+			}
+			if strings.ContainsRune(rel, 0) {
+				t.Errorf("tempFilePaths(%q) = %q contains a NUL byte", name, path)
+			}
+			if len(rel) > 128 {
+				t.Errorf("tempFilePaths(%q) name is %d bytes long", name, len(rel))
+			}
+		}
+	}
+}
+
+// fakeFFprobe points ffprobePath at a shell script running body for the
+// rest of the test.
+func fakeFFprobe(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffprobe")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := ffprobePath
+	ffprobePath = path
+	t.Cleanup(func() { ffprobePath = old })
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVideoSize = 640
+	minVideoSize     = 240
+	maxVideoSize     = 640
+	// videoSizeStep keeps the diameter aligned to the macroblock size so
+	// libx264 never has to pad the yuv420p frame.
+	videoSizeStep = 16
+
+	defaultMaxFileSize = 50 << 20
+	// Telegram caps video notes at one minute.
+	defaultMaxDuration = 60
+	// defaultMinDuration is about where Telegram stops showing a note's
+	// playback properly.
+	defaultMinDuration = time.Second
+
+	defaultMaxConcurrentJobs = 2
+
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = time.Second
+
+	defaultShutdownTimeout = 30 * time.Second
+
+	defaultRateLimit       = 5
+	defaultRateLimitWindow = time.Minute
+
+	defaultCacheSize = 32
+	// defaultCacheTTL stays below staleTempFileAge so the temp file sweeps
+	// never race a live entry.
+	defaultCacheTTL = 30 * time.Minute
+
+	defaultDataDir  = "data"
+	defaultS3Region = "us-east-1"
+
+	// defaultMaxResolution admits 4K in either orientation but not 8K.
+	defaultMaxResolution = 3840
+
+	defaultFFmpegTimeout = 2 * time.Minute
+
+	// defaultMaxFPS keeps notes smooth while halving the frames, and so
+	// roughly the size, of 60 fps phone footage.
+	defaultMaxFPS = 30
+
+	// defaultPreviewFeather softens the /preview circle over a few pixels,
+	// about what antialiasing gives.
+	defaultPreviewFeather = 2
+
+	// defaultMaxOutputSize is Telegram's upload limit for bots.
+	defaultMaxOutputSize = 50 << 20
+)
+
+// Update delivery modes selected by BOT_MODE.
+const (
+	modePolling = "polling"
+	modeWebhook = "webhook"
+)
+
+const (
+	defaultListenAddr  = ":8080"
+	defaultWebhookPath = "/webhook"
+	defaultHealthPath  = "/healthz"
+	defaultMetricsPath = "/metrics"
+)
+
+type config struct {
+	// Mode is polling unless BOT_MODE=webhook. WEBHOOK_URL and WEBHOOK_PATH
+	// are only read (and WEBHOOK_URL only required) in webhook mode.
+	Mode        string
+	WebhookURL  string
+	WebhookPath string
+	// WebhookSecret, from WEBHOOK_SECRET, is registered with Telegram and
+	// required on every webhook request. Empty disables the check.
+	WebhookSecret string
+	// WebhookSecretFile, from WEBHOOK_SECRET_FILE, is read for the secret
+	// instead of WEBHOOK_SECRET, and read again on SIGHUP so the secret can
+	// be rotated without a restart.
+	WebhookSecretFile string
+	// ListenAddr is where the HTTP server listens. It defaults to :8080 in
+	// webhook mode; in polling mode the server only starts if it is set.
+	ListenAddr  string
+	HealthPath  string
+	MetricsPath string
+
+	VideoSize   int
+	MaxFileSize int64
+	MaxDuration time.Duration
+
+	// MinDuration, from MIN_DURATION, is how long a note made from a
+	// shorter input is looped to; MIN_DURATION=0 keeps short clips as they
+	// are.
+	MinDuration time.Duration
+
+	MaxConcurrentJobs int
+
+	// Retry applies to Telegram API calls.
+	Retry retryPolicy
+
+	// AdminChatID may use operator commands such as /stats; 0 disables them.
+	AdminChatID int64
+
+	// ShutdownTimeout bounds how long in-flight conversions may keep running
+	// after SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// RateLimit conversions are allowed per chat every RateLimitWindow;
+	// RATE_LIMIT=0 disables the limit.
+	RateLimit       int
+	RateLimitWindow time.Duration
+
+	// VideoFallback sends a regular video when the user's privacy settings
+	// forbid video notes. Disable with VIDEO_FALLBACK=false.
+	VideoFallback bool
+
+	// CacheSize bounds the number of processed notes kept for re-sending;
+	// CACHE_SIZE=0 disables the cache.
+	CacheSize int
+	CacheTTL  time.Duration
+
+	// GroupConvertAll converts every video posted in groups. By default the
+	// bot only acts on group messages that mention it or reply to it.
+	GroupConvertAll bool
+
+	// StreamInput pipes Telegram downloads straight into ffmpeg instead of
+	// writing them to disk first. Enable with STREAM_INPUT=true.
+	StreamInput bool
+
+	// SizePicker offers inline buttons to choose the diameter of each video
+	// for users without a /setsize preference. Disable with SIZE_PICKER=false.
+	SizePicker bool
+
+	// WorkDir holds input and output files while they are processed. It is
+	// WORK_DIR if set, otherwise the system temp directory.
+	WorkDir string
+
+	// TempFileMaxAge is how old a temp file in WorkDir must be before the
+	// startup and periodic sweeps remove it, from TEMP_FILE_MAX_AGE. It is
+	// raised if needed to outlive cached notes and kept inputs.
+	TempFileMaxAge time.Duration
+
+	// DataDir holds state that must survive restarts, such as the chat
+	// list used by /broadcast. It is DATA_DIR, "data" by default.
+	DataDir string
+
+	// MaxResolution, from MAX_RESOLUTION, caps the longer side of input
+	// videos in pixels; larger sources are rejected before encoding.
+	MaxResolution int
+
+	// SettingsBackend is where per-user settings live: "json" (the
+	// default) saves them under DataDir, "memory" forgets them on restart.
+	// Set with SETTINGS_STORE.
+	SettingsBackend string
+
+	// FFmpegTimeout, from FFMPEG_TIMEOUT, kills a single ffmpeg run that
+	// takes longer than this.
+	FFmpegTimeout time.Duration
+
+	// DebugMode, from DEBUG_MODE, sends the stderr of failed ffmpeg runs to
+	// AdminChatID as a document.
+	DebugMode bool
+
+	// MaxFPS, from MAX_FPS, caps the frame rate of notes made from faster
+	// sources; MAX_FPS=0 keeps the source rate.
+	MaxFPS int
+
+	// MaxOutputSize, from MAX_OUTPUT_SIZE in bytes, is the largest result
+	// sent; bigger outputs are re-encoded with stronger compression.
+	MaxOutputSize int64
+
+	// DryRun, from DRY_RUN, validates the configuration and the ffmpeg
+	// install, then exits without contacting Telegram.
+	DryRun bool
+
+	// QuietMode, from QUIET_MODE, hides progress messages by default;
+	// users can still choose with /quiet.
+	QuietMode bool
+
+	// Workers, from WORKERS, is the number of queued jobs handled at once.
+	Workers int
+	// MaxQueue, from MAX_QUEUE, is how many jobs may wait before new videos
+	// are refused.
+	MaxQueue int
+
+	// TermsNotice is sent once to every chat before anything else: the
+	// contents of TERMS_FILE if set, otherwise TERMS_TEXT. Empty disables
+	// it.
+	TermsNotice string
+
+	// DedupWindow, from DEDUP_WINDOW, is how many recent update IDs are
+	// remembered to drop updates Telegram delivers twice.
+	DedupWindow int
+
+	// FFmpegPreset, from FFMPEG_PRESET, overrides the libx264 preset of
+	// every /quality level, trading encoding speed for compression. Empty
+	// keeps the per-level presets.
+	FFmpegPreset string
+
+	// FFmpegPath and FFprobePath, from FFMPEG_PATH and FFPROBE_PATH, point
+	// at custom builds of the tools; by default they are found on PATH.
+	FFmpegPath  string
+	FFprobePath string
+
+	// AudioNotes, from AUDIO_NOTES, turns voice messages and audio files
+	// into notes showing their waveform. Off by default.
+	AudioNotes bool
+
+	// OutputFormat, from OUTPUT_FORMAT, is mp4 with H.264 (the default) or
+	// webm with VP9 and Opus. Telegram only plays mp4 as round notes, so
+	// webm is meant for pipelines that post-process the files.
+	OutputFormat outputFormat
+
+	// AllowedChatIDs, from the comma-separated ALLOWED_CHAT_IDS, restricts
+	// the bot to these chats plus AdminChatID. Empty leaves it open to
+	// everyone.
+	AllowedChatIDs []int64
+
+	// PreviewFeather, from PREVIEW_FEATHER, is how many pixels the edge of
+	// the circle fades over in /preview stills; PREVIEW_FEATHER=0 cuts it
+	// hard. Notes themselves are masked by Telegram.
+	PreviewFeather int
+
+	// ArchiveBackend, from ARCHIVE_STORAGE, keeps a copy of every note
+	// sent: "local" under ArchiveDir, "s3" in the bucket described by S3.
+	// Empty, the default, archives nothing.
+	ArchiveBackend string
+	// ArchiveDir is ARCHIVE_DIR, by default "archive" under DataDir.
+	ArchiveDir string
+	// S3 comes from S3_ENDPOINT, S3_REGION (us-east-1 by default),
+	// S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	S3 s3Config
+}
+
+func loadConfig() config {
+	cfg := config{
+		Mode:        modeFromEnv(),
+		ListenAddr:  os.Getenv("LISTEN_ADDR"),
+		HealthPath:  envString("HEALTH_PATH", defaultHealthPath),
+		MetricsPath: envString("METRICS_PATH", defaultMetricsPath),
+		VideoSize:   videoSizeFromEnv(),
+		MaxFileSize: envInt64("MAX_FILE_SIZE", defaultMaxFileSize),
+		MaxDuration: time.Duration(envInt64("MAX_DURATION", defaultMaxDuration)) * time.Second,
+		MinDuration: envMinDuration(),
+
+		MaxConcurrentJobs: int(envInt64("MAX_CONCURRENT_JOBS", defaultMaxConcurrentJobs)),
+
+		Retry: retryPolicy{
+			MaxAttempts: int(envInt64("RETRY_MAX_ATTEMPTS", defaultRetryMaxAttempts)),
+			BaseDelay:   envDuration("RETRY_BASE_DELAY", defaultRetryBaseDelay),
+		},
+
+		AdminChatID: envChatID("ADMIN_CHAT_ID"),
+
+		ShutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout),
+
+		RateLimit:       envRateLimit(),
+		RateLimitWindow: envDuration("RATE_LIMIT_WINDOW", defaultRateLimitWindow),
+
+		VideoFallback: envBool("VIDEO_FALLBACK", true),
+
+		CacheSize: envCacheSize(),
+		CacheTTL:  envDuration("CACHE_TTL", defaultCacheTTL),
+
+		GroupConvertAll: envBool("GROUP_CONVERT_ALL", false),
+
+		StreamInput: envBool("STREAM_INPUT", false),
+		SizePicker:  envBool("SIZE_PICKER", true),
+
+		WorkDir:        envString("WORK_DIR", os.TempDir()),
+		TempFileMaxAge: envDuration("TEMP_FILE_MAX_AGE", staleTempFileAge),
+		DataDir:        envString("DATA_DIR", defaultDataDir),
+
+		MaxResolution: int(envInt64("MAX_RESOLUTION", defaultMaxResolution)),
+
+		SettingsBackend: settingsBackendFromEnv(),
+
+		FFmpegTimeout: envDuration("FFMPEG_TIMEOUT", defaultFFmpegTimeout),
+		DebugMode:     envBool("DEBUG_MODE", false),
+		MaxOutputSize: envInt64("MAX_OUTPUT_SIZE", defaultMaxOutputSize),
+		MaxFPS:        envMaxFPS(),
+
+		DryRun:    envBool("DRY_RUN", false),
+		QuietMode: envBool("QUIET_MODE", false),
+
+		Workers:  int(envInt64("WORKERS", defaultWorkers)),
+		MaxQueue: int(envInt64("MAX_QUEUE", defaultMaxQueue)),
+
+		DedupWindow: int(envInt64("DEDUP_WINDOW", defaultDedupWindow)),
+
+		FFmpegPreset: presetFromEnv(),
+		FFmpegPath:   envString("FFMPEG_PATH", "ffmpeg"),
+		FFprobePath:  envString("FFPROBE_PATH", "ffprobe"),
+
+		AudioNotes:   envBool("AUDIO_NOTES", false),
+		OutputFormat: outputFormatFromEnv(),
+
+		PreviewFeather: envPreviewFeather(),
+	}
+
+	// Files the bot still means to use live in WorkDir too, so the sweep
+	// must not catch those.
+	if floor := max(cfg.CacheTTL, lastInputTTL, pickerTTL) + tempSweepInterval; cfg.TempFileMaxAge < floor {
+		slog.Warn("TEMP_FILE_MAX_AGE is shorter than temp files are kept, raising it", "value", cfg.TempFileMaxAge, "min", floor)
+		cfg.TempFileMaxAge = floor
+	}
+
+	allowed, err := envChatIDs("ALLOWED_CHAT_IDS")
+	if err != nil {
+		fatal("Invalid ALLOWED_CHAT_IDS", "err", err)
+	}
+	cfg.AllowedChatIDs = allowed
+
+	cfg.ArchiveBackend = archiveBackendFromEnv()
+	cfg.ArchiveDir = envString("ARCHIVE_DIR", filepath.Join(cfg.DataDir, "archive"))
+	cfg.S3 = s3Config{
+		Region:          envString("S3_REGION", defaultS3Region),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	cfg.S3.Endpoint = strings.TrimRight(envString("S3_ENDPOINT", "https://s3."+cfg.S3.Region+".amazonaws.com"), "/")
+
+	cfg.TermsNotice = os.Getenv("TERMS_TEXT")
+	if path := os.Getenv("TERMS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fatal("Error reading TERMS_FILE", "path", path, "err", err)
+		}
+		cfg.TermsNotice = strings.TrimSpace(string(data))
+	}
+
+	if cfg.Mode == modeWebhook {
+		cfg.WebhookURL = strings.TrimRight(os.Getenv("WEBHOOK_URL"), "/")
+		if cfg.WebhookURL == "" {
+			fatal("WEBHOOK_URL environment variable is not set")
+		}
+		cfg.WebhookPath = envString("WEBHOOK_PATH", defaultWebhookPath)
+		cfg.WebhookSecretFile = os.Getenv("WEBHOOK_SECRET_FILE")
+		secret, err := cfg.readWebhookSecret()
+		if err != nil {
+			fatal("Invalid webhook secret", "err", err)
+		}
+		cfg.WebhookSecret = secret
+		if cfg.ListenAddr == "" {
+			cfg.ListenAddr = defaultListenAddr
+		}
+	}
+
+	return cfg
+}
+
+// summary formats the resolved configuration for the log with secrets
+// redacted.
+func (c config) summary() string {
+	if c.WebhookSecret != "" {
+		c.WebhookSecret = "[redacted]"
+	}
+	if c.S3.SecretAccessKey != "" {
+		c.S3.SecretAccessKey = "[redacted]"
+	}
+	return fmt.Sprintf("%+v", c)
+}
+
+func modeFromEnv() string {
+	switch mode := strings.ToLower(os.Getenv("BOT_MODE")); mode {
+	case "", modePolling:
+		return modePolling
+	case modeWebhook:
+		return modeWebhook
+	default:
+		slog.Warn("Unknown BOT_MODE, using default", "value", mode, "default", modePolling)
+		return modePolling
+	}
+}
+
+// validWebhookSecret reports whether Telegram accepts s as a secret_token.
+func validWebhookSecret(s string) bool {
+	if len(s) > 256 {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// readWebhookSecret returns the contents of WebhookSecretFile if set,
+// otherwise WEBHOOK_SECRET, checking that Telegram will accept it.
+func (c config) readWebhookSecret() (string, error) {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if c.WebhookSecretFile != "" {
+		data, err := os.ReadFile(c.WebhookSecretFile)
+		if err != nil {
+			return "", err
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret != "" && !validWebhookSecret(secret) {
+		return "", errors.New("webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	return secret, nil
+}
+
+func settingsBackendFromEnv() string {
+	switch backend := strings.ToLower(os.Getenv("SETTINGS_STORE")); backend {
+	case "", settingsBackendJSON:
+		return settingsBackendJSON
+	case settingsBackendMemory:
+		return settingsBackendMemory
+	default:
+		slog.Warn("Unknown SETTINGS_STORE, using default", "value", backend, "default", settingsBackendJSON)
+		return settingsBackendJSON
+	}
+}
+
+func archiveBackendFromEnv() string {
+	switch backend := strings.ToLower(os.Getenv("ARCHIVE_STORAGE")); backend {
+	case "", storageBackendLocal, storageBackendS3:
+		return backend
+	default:
+		slog.Warn("Unknown ARCHIVE_STORAGE, not archiving", "value", backend)
+		return ""
+	}
+}
+
+func presetFromEnv() string {
+	preset := strings.ToLower(os.Getenv("FFMPEG_PRESET"))
+	if preset == "" || slices.Contains(x264Presets, preset) {
+		return preset
+	}
+	slog.Warn("Unknown FFMPEG_PRESET, using per-quality presets", "value", preset, "valid", strings.Join(x264Presets, ","))
+	return ""
+}
+
+func outputFormatFromEnv() outputFormat {
+	name := strings.ToLower(os.Getenv("OUTPUT_FORMAT"))
+	if name == "" {
+		return outputFormats[formatMP4]
+	}
+	if format, ok := outputFormats[name]; ok {
+		return format
+	}
+	slog.Warn("Unknown OUTPUT_FORMAT, using default", "value", name, "default", formatMP4)
+	return outputFormats[formatMP4]
+}
+
+func envRateLimit() int {
+	if os.Getenv("RATE_LIMIT") == "0" {
+		return 0
+	}
+	return int(envInt64("RATE_LIMIT", defaultRateLimit))
+}
+
+func envMaxFPS() int {
+	if os.Getenv("MAX_FPS") == "0" {
+		return 0
+	}
+	return int(envInt64("MAX_FPS", defaultMaxFPS))
+}
+
+func envMinDuration() time.Duration {
+	if os.Getenv("MIN_DURATION") == "0" {
+		return 0
+	}
+	return envDuration("MIN_DURATION", defaultMinDuration)
+}
+
+func envPreviewFeather() int {
+	if os.Getenv("PREVIEW_FEATHER") == "0" {
+		return 0
+	}
+	return int(envInt64("PREVIEW_FEATHER", defaultPreviewFeather))
+}
+
+func envCacheSize() int {
+	if os.Getenv("CACHE_SIZE") == "0" {
+		return 0
+	}
+	return int(envInt64("CACHE_SIZE", defaultCacheSize))
+}
+
+// envDuration reads a positive time.ParseDuration value such as "500ms".
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid environment value, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return d
+}
+
+// envChatID reads a Telegram chat ID, which may be negative for groups.
+func envChatID(name string) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid chat ID, ignoring", "name", name, "value", value)
+		return 0
+	}
+
+	return id
+}
+
+// envChatIDs parses a comma-separated list of chat IDs. Unlike envChatID
+// it fails on a bad entry, since the list restricts access and a typo
+// must not silently open or close the bot.
+func envChatIDs(name string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(os.Getenv(name), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid chat ID %q", name, field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid environment value, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return b
+}
+
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt64 reads a positive integer from the environment, warning and
+// falling back to def when the value is malformed.
+func envInt64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		slog.Warn("Invalid environment value, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+
+	return n
+}
+
+func videoSizeFromEnv() int {
+	value := os.Getenv("VIDEO_SIZE")
+	if value == "" {
+		return defaultVideoSize
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || !validVideoSize(size) {
+		slog.Warn("Invalid VIDEO_SIZE, using default", "value", value,
+			"min", minVideoSize, "max", maxVideoSize, "step", videoSizeStep, "default", defaultVideoSize)
+		return defaultVideoSize
+	}
+
+	return size
+}
+
+func validVideoSize(size int) bool {
+	return size >= minVideoSize && size <= maxVideoSize && size%videoSizeStep == 0
+}
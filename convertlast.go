@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// lastInputTTL is how long a chat's last video is kept for /convertlast.
+// TempFileMaxAge is kept above it so the temp file sweeps never race it.
+const lastInputTTL = 30 * time.Minute
+
+// lastInput is a downloaded video kept after its conversion so it can be
+// converted again with other options.
+type lastInput struct {
+	path         string
+	fileUniqueID string
+	isAnimation  bool
+	duration     time.Duration
+	expires      time.Time
+}
+
+// lastInputs holds the most recent input of each chat. It owns their files
+// and deletes them when replaced or expired.
+type lastInputs struct {
+	mu    sync.Mutex
+	items map[int64]lastInput
+}
+
+func newLastInputs() *lastInputs {
+	return &lastInputs{items: make(map[int64]lastInput)}
+}
+
+// put makes in the last input of chatID, replacing any older one. It takes
+// ownership of in.path.
+func (l *lastInputs) put(chatID int64, in lastInput) {
+	in.expires = time.Now().Add(lastInputTTL)
+	touch(in.path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep()
+	if old, ok := l.items[chatID]; ok {
+		os.Remove(old.path)
+	}
+	l.items[chatID] = in
+}
+
+// take removes and returns the last input of chatID so it can't be
+// deleted while in use. The caller becomes responsible for its file until
+// it hands it back with restore.
+func (l *lastInputs) take(chatID int64) (lastInput, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep()
+	in, ok := l.items[chatID]
+	delete(l.items, chatID)
+	return in, ok
+}
+
+// restore gives back an input from take with a fresh TTL, unless a newer
+// video arrived meanwhile, in which case in is deleted.
+func (l *lastInputs) restore(chatID int64, in lastInput) {
+	in.expires = time.Now().Add(lastInputTTL)
+	touch(in.path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.items[chatID]; ok {
+		os.Remove(in.path)
+		return
+	}
+	l.items[chatID] = in
+}
+
+// touch bumps the modification time of path, which the temp file sweeps go
+// by, so a kept input isn't removed while its entry is still live.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// sweep drops expired inputs. l.mu must be held.
+func (l *lastInputs) sweep() {
+	now := time.Now()
+	for chatID, in := range l.items {
+		if now.After(in.expires) {
+			os.Remove(in.path)
+			delete(l.items, chatID)
+		}
+	}
+}
+
+// handleConvertLast converts the chat's last video again, with the options
+// given as in a caption, e.g. "/convertlast size=480 quality=high".
+func (a *app) handleConvertLast(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	options, _, err := parseCaptionOptions(message.CommandArguments())
+	if err != nil {
+		sendText(ctx, bot, chatID, captionOptionError(lang, err), textError)
+		return
+	}
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	in, ok := a.lastInputs.take(chatID)
+	if !ok {
+		sendText(ctx, bot, chatID, localize(lang, msgConvertLastExpired, int(lastInputTTL.Minutes())), textError)
+		return
+	}
+	defer a.lastInputs.restore(chatID, in)
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	logger := requestLogger(chatID, "file_unique_id", in.fileUniqueID)
+	ctx = contextWithLogger(ctx, logger)
+
+	if len(options.applied) > 0 {
+		a.sendStatus(ctx, chatID, localize(lang, msgOptionsApplied, strings.Join(options.applied, ", ")))
+	}
+
+	c := conversion{
+		chatID:      chatID,
+		lang:        lang,
+		videoSize:   a.videoSize(chatID),
+		isAnimation: in.isAnimation,
+		duration:    in.duration,
+		start:       options.start,
+		crop:        options.crop,
+		quality:     options.quality,
+		fit:         options.fit,
+	}
+	if options.size != 0 {
+		c.videoSize = options.size
+	}
+	a.applyTarget(ctx, &c, options.target, message)
+	c.cacheKey = a.cacheKey(in.fileUniqueID, c)
+	if a.sendCached(ctx, c) {
+		return
+	}
+
+	_, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, "video"+filepath.Ext(in.path), a.cfg.OutputFormat.Ext)
+	a.convertAndSend(ctx, c, in.path, outputPath)
+}
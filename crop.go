@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCrop = errors.New("invalid crop position")
+
+// cropOffset is one coordinate of a crop position: the fraction of the
+// space left around the square, 0 for the left or top edge and 1 for the
+// right or bottom, or, when pixels is set, an absolute offset.
+type cropOffset struct {
+	value  float64
+	pixels bool
+}
+
+// cropPosition says where in the frame the square for the circle is cut
+// from. The zero value is the centre.
+type cropPosition struct {
+	x, y cropOffset
+	set  bool
+}
+
+// parseCropPosition reads the X:Y value of a crop= caption option.
+func parseCropPosition(value string) (cropPosition, error) {
+	xs, ys, ok := strings.Cut(value, ":")
+	if !ok {
+		return cropPosition{}, errInvalidCrop
+	}
+	x, err := parseCropOffset(xs)
+	if err != nil {
+		return cropPosition{}, err
+	}
+	y, err := parseCropOffset(ys)
+	if err != nil {
+		return cropPosition{}, err
+	}
+	return cropPosition{x: x, y: y, set: true}, nil
+}
+
+// parseCropOffset reads a fraction from 0 to 1 written with a decimal
+// point, such as 0.25 or 1.0, or a whole number of pixels.
+func parseCropOffset(s string) (cropOffset, error) {
+	if strings.Contains(s, ".") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 || f > 1 {
+			return cropOffset{}, errInvalidCrop
+		}
+		return cropOffset{value: f}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return cropOffset{}, errInvalidCrop
+	}
+	return cropOffset{value: float64(n), pixels: true}, nil
+}
+
+// fits reports whether pixel offsets leave the whole square inside an
+// upright width x height frame. Fractions always fit.
+func (p cropPosition) fits(width, height int) bool {
+	side := min(width, height)
+	return (!p.x.pixels || int(p.x.value) <= width-side) &&
+		(!p.y.pixels || int(p.y.value) <= height-side)
+}
+
+// String is the position as written in the caption, or "" for the centre.
+func (p cropPosition) String() string {
+	if !p.set {
+		return ""
+	}
+	return p.x.String() + ":" + p.y.String()
+}
+
+func (o cropOffset) String() string {
+	if o.pixels {
+		return strconv.Itoa(int(o.value))
+	}
+	return strconv.FormatFloat(o.value, 'f', -1, 64)
+}
+
+// expr is the offset as an ffmpeg crop x or y expression, slack being the
+// expression for the space left over, e.g. iw-ow.
+func (o cropOffset) expr(slack string) string {
+	if o.pixels {
+		return o.String()
+	}
+	return fmt.Sprintf("(%s)*%s", slack, o)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "sync"
+
+// defaultDedupWindow is how many recent update IDs are remembered, which
+// comfortably covers Telegram's redelivery of unacknowledged webhooks.
+const defaultDedupWindow = 1000
+
+// updateDeduper remembers the last size update IDs so a redelivered
+// update isn't handled twice. Memory stays bounded: the oldest ID is
+// forgotten when a new one arrives.
+type updateDeduper struct {
+	mu   sync.Mutex
+	seen map[int]struct{}
+	ring []int
+	next int
+}
+
+func newUpdateDeduper(size int) *updateDeduper {
+	return &updateDeduper{
+		seen: make(map[int]struct{}, size),
+		ring: make([]int, 0, size),
+	}
+}
+
+// duplicate records id and reports whether it had already been seen.
+func (d *updateDeduper) duplicate(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+
+	if len(d.ring) < cap(d.ring) {
+		d.ring = append(d.ring, id)
+	} else {
+		delete(d.seen, d.ring[d.next])
+		d.ring[d.next] = id
+		d.next = (d.next + 1) % len(d.ring)
+	}
+	d.seen[id] = struct{}{}
+	return false
+}
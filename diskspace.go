@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// storageAlertInterval spaces out admin alerts while the disk stays full,
+// since every job in the meantime fails the same way.
+const storageAlertInterval = 10 * time.Minute
+
+// outOfStorage reports whether err is a write failing for lack of disk
+// space, either directly or as reported in ffmpeg's log.
+func outOfStorage(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	var ffErr *ffmpegError
+	return errors.As(err, &ffErr) && strings.Contains(ffErr.stderr, "No space left on device")
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding dir.
+func freeSpace(dir string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, err
+	}
+	return fs.Bavail * uint64(fs.Bsize), nil
+}
+
+// hasRoomForJob checks, before a download starts, that WorkDir can hold
+// the largest accepted input and output. If the space can't be read the
+// job goes ahead and any failure is caught when writing.
+func (a *app) hasRoomForJob(ctx context.Context, chatID int64, lang string) bool {
+	free, err := freeSpace(a.cfg.WorkDir)
+	if err != nil {
+		loggerFromContext(ctx).Warn("Error checking free disk space", "err", err)
+		return true
+	}
+	if need := a.cfg.MaxFileSize + a.cfg.MaxOutputSize; free < uint64(need) {
+		a.reportOutOfStorage(ctx, chatID, lang, fmt.Errorf("%d bytes free in %s, %d needed", free, a.cfg.WorkDir, need))
+		return false
+	}
+	return true
+}
+
+// storageAlert throttles the admin alerts sent by reportOutOfStorage.
+type storageAlert struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// due reports whether an alert should be sent now, recording it if so.
+func (s *storageAlert) due(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.last) < storageAlertInterval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// reportOutOfStorage tells the user the server has no room for their
+// video and alerts the operators, in the log and the admin chat.
+func (a *app) reportOutOfStorage(ctx context.Context, chatID int64, lang string, err error) {
+	loggerFromContext(ctx).Error("Work directory is out of disk space", "work_dir", a.cfg.WorkDir, "err", err)
+	a.recordFailure()
+	sendText(ctx, a.bot, chatID, localize(lang, msgOutOfStorage), textError)
+
+	if a.cfg.AdminChatID == 0 || !a.storageAlert.due(time.Now()) {
+		return
+	}
+	alert := tgbotapi.NewMessage(a.cfg.AdminChatID, truncateText(
+		fmt.Sprintf("Out of disk space in %s, jobs are failing: %v", a.cfg.WorkDir, err), maxMessageLength))
+	if _, err := a.bot.Send(alert); err != nil {
+		loggerFromContext(ctx).Warn("Error sending storage alert to admin", "err", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// Processing time is modelled as proportional to the output length times
+// the input resolution in megapixels, the bulk of what libx264 has to
+// decode and scale. Until real conversions have been timed the rate below
+// is assumed, roughly a 1080p minute in 45 seconds.
+const (
+	defaultSecondsPerUnit = 0.4
+	// etaMargin pads estimates so users are pleasantly surprised rather
+	// than kept waiting past the promised time.
+	etaMargin = 1.5
+	etaStep   = 5 * time.Second
+)
+
+// workUnits is the model's measure of how much work a conversion is.
+func workUnits(output time.Duration, width, height int) float64 {
+	return output.Seconds() * float64(width*height) / 1e6
+}
+
+// estimateProcessing returns a conservative guess at how long a conversion
+// of units takes, rounded up to etaStep, using the throughput measured
+// since the stats were last reset. It returns 0 when units is unknown.
+func (a *app) estimateProcessing(units float64) time.Duration {
+	if units <= 0 {
+		return 0
+	}
+	rate, ok := a.stats.secondsPerUnit()
+	if !ok {
+		rate = defaultSecondsPerUnit
+	}
+	eta := time.Duration(units * rate * etaMargin * float64(time.Second))
+	return (eta + etaStep - 1).Truncate(etaStep)
+}
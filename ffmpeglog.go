@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ffmpegLogLimit is how much of ffmpeg's stderr is kept for DEBUG_MODE
+// reports; the end of the log is where the failure is explained.
+const ffmpegLogLimit = 64 << 10
+
+// tailBuffer is an io.Writer that keeps only the last limit bytes written.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// ffmpegError is a failed ffmpeg run together with the tail of its stderr.
+type ffmpegError struct {
+	err    error
+	stderr string
+}
+
+func (e *ffmpegError) Error() string { return e.err.Error() }
+func (e *ffmpegError) Unwrap() error { return e.err }
+
+// killedBySignal reports whether err is from a process that was killed
+// by SIGKILL without us asking, which on Linux almost always means the
+// kernel's OOM killer. Cancellations and timeouts must be ruled out first,
+// since exec.CommandContext kills with SIGKILL too.
+func killedBySignal(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGKILL
+}
+
+// reportFFmpegFailure tells the user a conversion failed, hinting at the
+// cause when ffmpeg ran out of memory, and sends the log to the admin.
+// Running out of disk is reported as such instead.
+func (a *app) reportFFmpegFailure(ctx context.Context, c conversion, err error) {
+	a.metrics.ffmpegFailures.Inc()
+	if outOfStorage(err) {
+		a.reportOutOfStorage(ctx, c.chatID, c.lang, err)
+		return
+	}
+	a.recordFailure()
+
+	key := msgProcessFailed
+	if killedBySignal(err) {
+		loggerFromContext(ctx).Warn("ffmpeg was killed, possibly out of memory", "err", err)
+		key = msgProcessTooDemanding
+	}
+	sendText(ctx, a.bot, c.chatID, localize(c.lang, key), textError)
+	a.sendFFmpegLog(ctx, c.chatID, err)
+}
+
+// sendFFmpegLog uploads the stderr of a failed run to the admin chat when
+// DEBUG_MODE is on. Users only ever see the friendly error message.
+func (a *app) sendFFmpegLog(ctx context.Context, chatID int64, err error) {
+	var ffErr *ffmpegError
+	if !a.cfg.DebugMode || a.cfg.AdminChatID == 0 || !errors.As(err, &ffErr) {
+		return
+	}
+
+	doc := tgbotapi.NewDocument(a.cfg.AdminChatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("ffmpeg-%d.log", chatID),
+		Bytes: []byte(ffErr.stderr),
+	})
+	doc.Caption = truncateText(fmt.Sprintf("ffmpeg failed for chat %d: %v", chatID, ffErr.err), 1024)
+	if _, err := a.bot.Send(doc); err != nil {
+		loggerFromContext(ctx).Warn("Error sending ffmpeg log to admin", "err", err)
+	}
+}
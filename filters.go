@@ -0,0 +1,117 @@
+package main
+
+import "fmt"
+
+// videoFilter returns the -vf filtergraph for opts.
+func videoFilter(opts videoOptions) string {
+	var filter string
+	switch {
+	case opts.Letterbox:
+		filter = buildLetterboxFilter()
+	case opts.Square:
+		// Cropping and fitting are both no-ops on a square frame.
+		filter = buildScaleFilter(opts.Size)
+	case opts.Fit:
+		filter = buildFitFilter(opts.Size)
+	default:
+		filter = buildCircularFilter(opts.Size, opts.Crop)
+	}
+
+	if rotate := buildRotationFilter(opts.Rotation); rotate != "" {
+		return rotate + "," + filter
+	}
+	return filter
+}
+
+// buildRotationFilter turns frames clockwise by degrees so the crop sees
+// them upright. It returns "" for no rotation.
+func buildRotationFilter(degrees int) string {
+	switch degrees {
+	case 90:
+		return "transpose=clock"
+	case 180:
+		return "hflip,vflip"
+	case 270:
+		return "transpose=cclock"
+	default:
+		return ""
+	}
+}
+
+// buildCircularFilter crops the square at pos, by default the centre, and
+// scales it to size. The comma inside min() is escaped so ffmpeg doesn't
+// read it as a filter separator.
+func buildCircularFilter(size int, pos cropPosition) string {
+	crop := "crop=min(iw\\,ih):min(iw\\,ih)"
+	if pos.set {
+		crop += ":" + pos.x.expr("iw-ow") + ":" + pos.y.expr("ih-oh")
+	}
+	return fmt.Sprintf("%s,scale=%d:%d,format=yuv420p", crop, size, size)
+}
+
+// buildScaleFilter scales an already square frame to size.
+func buildScaleFilter(size int) string {
+	return fmt.Sprintf("scale=%d:%d,format=yuv420p", size, size)
+}
+
+// canCopyVideo reports whether a square input of the given width, codec
+// and pixel format can be sent without re-encoding: it must already be
+// yuv420p in the output format's codec at exactly the note size and need
+// no rotation, seeking, looping or frame rate cap.
+func canCopyVideo(codec, pixFmt string, width int, opts videoOptions) bool {
+	return opts.Square && !opts.Letterbox && width == opts.Size &&
+		codec == opts.Format.CopyableVideo && pixFmt == "yuv420p" && opts.Watermark == "" &&
+		opts.Rotation == 0 && opts.Start == 0 && !opts.Loop && !opts.needsPadding() && opts.FrameRate == 0
+}
+
+// buildFitFilter scales the whole frame to fit inside a size square and
+// overlays it on a blurred, cropped copy of itself.
+func buildFitFilter(size int) string {
+	return fmt.Sprintf("split[bg][fg];"+
+		"[bg]scale=%[1]d:%[1]d:force_original_aspect_ratio=increase,crop=%[1]d:%[1]d,boxblur=20:5[bg];"+
+		"[fg]scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease:force_divisible_by=2[fg];"+
+		"[bg][fg]overlay=(W-w)/2:(H-h)/2,format=yuv420p",
+		size)
+}
+
+// buildLetterboxFilter pads a frame to 16:9 at its own height with black
+// bars either side, keeping the width even for yuv420p.
+func buildLetterboxFilter() string {
+	return "pad=w=ceil(ih*16/9/2)*2:h=ih:x=(ow-iw)/2:y=0:color=black,format=yuv420p"
+}
+
+// buildWatermarkFilter is a -filter_complex graph applying the video
+// filter base to the first input and overlaying the second, scaled to a
+// quarter of the note, centred near the bottom where the circle is still
+// wide enough to show it whole. The result is labelled [v].
+func buildWatermarkFilter(base string, size int) string {
+	return fmt.Sprintf("[0:v]%s[base];"+
+		"[1:v]scale=%d:-2[wm];"+
+		"[base][wm]overlay=(W-w)/2:H-h-H/10,format=yuv420p[v]",
+		base, size/4)
+}
+
+// waveformBackground is the solid colour behind audio waveforms.
+const waveformBackground = "0x2b5278"
+
+// buildWaveformFilter is a -filter_complex graph drawing the first audio
+// stream as a white waveform on a solid size square, labelled [v].
+func buildWaveformFilter(size int) string {
+	return fmt.Sprintf("color=c=%[2]s:s=%[1]dx%[1]d[bg];"+
+		"[0:a]showwaves=s=%[1]dx%[1]d:mode=cline:colors=white[waves];"+
+		"[bg][waves]overlay=shortest=1,format=yuv420p[v]",
+		size, waveformBackground)
+}
+
+// buildCircleMaskFilter makes everything outside the inscribed circle
+// transparent, showing a still as the round note will crop it. The edge
+// fades out over feather pixels centred on the circle, or is cut hard if
+// feather is 0. Only stills use it: notes are yuv420p, which has no alpha,
+// and Telegram masks them itself.
+func buildCircleMaskFilter(feather int) string {
+	alpha := "if(lte(hypot(X-W/2,Y-H/2),W/2),255,0)"
+	if feather > 0 {
+		alpha = fmt.Sprintf("255*clip((W/2-hypot(X-W/2,Y-H/2))/%d+0.5,0,1)", feather)
+	}
+	return "format=rgba,geq=r='r(X,Y)':g='g(X,Y)':b='b(X,Y)':a='" + alpha + "'"
+}
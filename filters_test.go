@@ -0,0 +1,207 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCircularFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		pos  cropPosition
+		want string
+	}{
+		{
+			name: "default size",
+			size: defaultVideoSize,
+			want: "crop=min(iw\\,ih):min(iw\\,ih),scale=640:640,format=yuv420p",
+		},
+		{
+			name: "custom size",
+			size: 384,
+			want: "crop=min(iw\\,ih):min(iw\\,ih),scale=384:384,format=yuv420p",
+		},
+		{
+			name: "fractional position",
+			size: 384,
+			pos:  cropPosition{x: cropOffset{value: 0.25}, y: cropOffset{value: 1}, set: true},
+			want: "crop=min(iw\\,ih):min(iw\\,ih):(iw-ow)*0.25:(ih-oh)*1,scale=384:384,format=yuv420p",
+		},
+		{
+			name: "pixel position",
+			size: 384,
+			pos:  cropPosition{x: cropOffset{value: 120, pixels: true}, y: cropOffset{pixels: true}, set: true},
+			want: "crop=min(iw\\,ih):min(iw\\,ih):120:0,scale=384:384,format=yuv420p",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildCircularFilter(tt.size, tt.pos); got != tt.want {
+				t.Errorf("buildCircularFilter(%d, %v) = %q, want %q", tt.size, tt.pos, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFitFilter(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{
+			size: defaultVideoSize,
+			want: "split[bg][fg];" +
+				"[bg]scale=640:640:force_original_aspect_ratio=increase,crop=640:640,boxblur=20:5[bg];" +
+				"[fg]scale=640:640:force_original_aspect_ratio=decrease:force_divisible_by=2[fg];" +
+				"[bg][fg]overlay=(W-w)/2:(H-h)/2,format=yuv420p",
+		},
+		{
+			size: 384,
+			want: "split[bg][fg];" +
+				"[bg]scale=384:384:force_original_aspect_ratio=increase,crop=384:384,boxblur=20:5[bg];" +
+				"[fg]scale=384:384:force_original_aspect_ratio=decrease:force_divisible_by=2[fg];" +
+				"[bg][fg]overlay=(W-w)/2:(H-h)/2,format=yuv420p",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := buildFitFilter(tt.size); got != tt.want {
+			t.Errorf("buildFitFilter(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestVideoFilterFitOrCrop(t *testing.T) {
+	tests := []struct {
+		name string
+		opts videoOptions
+		want string
+	}{
+		{"crop", videoOptions{Size: 384}, buildCircularFilter(384, cropPosition{})},
+		{"fit", videoOptions{Size: 384, Fit: true}, buildFitFilter(384)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := videoFilter(tt.opts); got != tt.want {
+				t.Errorf("videoFilter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCircularFilterEscaping guards the escaped comma in min(): unescaped,
+// ffmpeg splits the graph there and the crop gets one argument.
+func TestCircularFilterEscaping(t *testing.T) {
+	for _, size := range []int{minVideoSize, 384, maxVideoSize} {
+		got := buildCircularFilter(size, cropPosition{})
+		if strings.Count(got, "min(iw\\,ih)") != 2 {
+			t.Errorf("buildCircularFilter(%d) = %q, want min(iw\\,ih) twice", size, got)
+		}
+		if strings.Contains(got, "min(iw,ih)") {
+			t.Errorf("buildCircularFilter(%d) = %q has an unescaped comma in min()", size, got)
+		}
+	}
+}
+
+func TestVideoFilterRotation(t *testing.T) {
+	crop := buildCircularFilter(384, cropPosition{})
+	tests := []struct {
+		rotation int
+		want     string
+	}{
+		{0, crop},
+		{90, "transpose=clock," + crop},
+		{180, "hflip,vflip," + crop},
+		{270, "transpose=cclock," + crop},
+	}
+
+	for _, tt := range tests {
+		if got := videoFilter(videoOptions{Size: 384, Rotation: tt.rotation}); got != tt.want {
+			t.Errorf("videoFilter() with rotation %d = %q, want %q", tt.rotation, got, tt.want)
+		}
+	}
+}
+
+func TestVideoFilterSquareSkipsCrop(t *testing.T) {
+	square := videoFilter(videoOptions{Size: 384, Square: true})
+	if want := "scale=384:384,format=yuv420p"; square != want {
+		t.Errorf("videoFilter() for a square input = %q, want %q", square, want)
+	}
+	if strings.Contains(square, "crop") {
+		t.Errorf("videoFilter() for a square input = %q, want no crop", square)
+	}
+
+	// Fit mode has nothing to fit on a square frame either.
+	if got := videoFilter(videoOptions{Size: 384, Square: true, Fit: true}); got != square {
+		t.Errorf("videoFilter() for a square input in fit mode = %q, want %q", got, square)
+	}
+
+	if got := videoFilter(videoOptions{Size: 384}); got == square || !strings.HasPrefix(got, "crop=") {
+		t.Errorf("videoFilter() for a non-square input = %q, want a crop", got)
+	}
+}
+
+func TestCanCopyVideo(t *testing.T) {
+	mp4 := outputFormats[formatMP4]
+	base := videoOptions{Size: 384, Square: true, Format: mp4}
+
+	tests := []struct {
+		name   string
+		codec  string
+		pixFmt string
+		width  int
+		opts   func(*videoOptions)
+		want   bool
+	}{
+		{name: "square at size", codec: "h264", pixFmt: "yuv420p", width: 384, want: true},
+		{name: "not square", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Square = false }},
+		{name: "other size", codec: "h264", pixFmt: "yuv420p", width: 400},
+		{name: "other codec", codec: "hevc", pixFmt: "yuv420p", width: 384},
+		{name: "other pixel format", codec: "h264", pixFmt: "yuv444p", width: 384},
+		{name: "rotated", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Rotation = 90 }},
+		{name: "watermarked", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Watermark = "wm.png" }},
+		{name: "start offset", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Start = time.Second }},
+		{name: "looped", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Loop = true }},
+		{name: "letterboxed", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Letterbox = true }},
+		{name: "padded to min duration", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Length, o.MinDuration = 500*time.Millisecond, time.Second }},
+		{name: "long enough for min duration", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.Length, o.MinDuration = 2*time.Second, time.Second }, want: true},
+		{name: "frame rate capped", codec: "h264", pixFmt: "yuv420p", width: 384, opts: func(o *videoOptions) { o.FrameRate = 30 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			if got := canCopyVideo(tt.codec, tt.pixFmt, tt.width, opts); got != tt.want {
+				t.Errorf("canCopyVideo(%q, %q, %d) = %t, want %t", tt.codec, tt.pixFmt, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCircleMaskFilter(t *testing.T) {
+	const prefix = "format=rgba,geq=r='r(X,Y)':g='g(X,Y)':b='b(X,Y)':a='"
+	tests := []struct {
+		name    string
+		feather int
+		want    string
+	}{
+		{"hard edge", 0, prefix + "if(lte(hypot(X-W/2,Y-H/2),W/2),255,0)'"},
+		{"feathered", 4, prefix + "255*clip((W/2-hypot(X-W/2,Y-H/2))/4+0.5,0,1)'"},
+		{"wide feather", 16, prefix + "255*clip((W/2-hypot(X-W/2,Y-H/2))/16+0.5,0,1)'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildCircleMaskFilter(tt.feather); got != tt.want {
+				t.Errorf("buildCircleMaskFilter(%d) = %q, want %q", tt.feather, got, tt.want)
+			}
+		})
+	}
+}
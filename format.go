@@ -0,0 +1,52 @@
+package main
+
+import "strconv"
+
+// Output containers selectable with OUTPUT_FORMAT.
+const (
+	formatMP4  = "mp4"
+	formatWebM = "webm"
+)
+
+// outputFormat is a container and the codecs written into it.
+type outputFormat struct {
+	Name string
+	Ext  string
+	// CopyableVideo is the input video codec that may be stream-copied.
+	CopyableVideo string
+	// CopyableAudio lists input audio codecs the container takes as-is.
+	CopyableAudio map[string]bool
+}
+
+var outputFormats = map[string]outputFormat{
+	formatMP4: {
+		Name:          formatMP4,
+		Ext:           ".mp4",
+		CopyableVideo: "h264",
+		CopyableAudio: map[string]bool{"aac": true, "mp3": true},
+	},
+	formatWebM: {
+		Name:          formatWebM,
+		Ext:           ".webm",
+		CopyableVideo: "vp9",
+		CopyableAudio: map[string]bool{"opus": true, "vorbis": true},
+	},
+}
+
+// videoArgs returns the ffmpeg arguments encoding video for f. libvpx has
+// no presets, and needs -b:v 0 for -crf to mean constant quality.
+func (f outputFormat) videoArgs(enc encoderSettings) []string {
+	if f.Name == formatWebM {
+		return []string{"-c:v", "libvpx-vp9", "-crf", strconv.Itoa(enc.CRF), "-b:v", "0", "-row-mt", "1"}
+	}
+	return []string{"-c:v", "libx264", "-crf", strconv.Itoa(enc.CRF), "-preset", enc.Preset}
+}
+
+// audioArgs returns the ffmpeg arguments encoding a stereo track for f.
+func (f outputFormat) audioArgs() []string {
+	codec := "aac"
+	if f.Name == formatWebM {
+		codec = "libopus"
+	}
+	return []string{"-c:a", codec, "-b:a", audioBitrate, "-ac", "2"}
+}
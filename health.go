@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type healthStatus struct {
+	Status     string `json:"status"`
+	Uptime     string `json:"uptime"`
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	BuildDate  string `json:"build_date"`
+	WebhookSet bool   `json:"webhook_set"`
+}
+
+// handleHealth reports liveness without touching the Telegram API, so a
+// Telegram outage doesn't fail orchestrator probes.
+func (a *app) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(healthStatus{
+		Status:     "ok",
+		Uptime:     time.Since(a.startedAt).Round(time.Second).String(),
+		Version:    version,
+		Commit:     commit,
+		BuildDate:  buildDate,
+		WebhookSet: a.webhookSet.Load(),
+	})
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultLanguage = "en"
+
+type messageKey int
+
+const (
+	msgSendVideo messageKey = iota
+	msgHelp
+	msgNothingToCancel
+	msgCancelled
+	msgFitUsage
+	msgFitOn
+	msgFitOff
+	msgCurrentSize
+	msgInvalidSize
+	msgSizeSet
+	msgInvalidVideo
+	msgNotVideo
+	msgTooLarge
+	msgTelegramTooBig
+	msgDownloadFailed
+	msgProcessFailed
+	msgTrimmed
+	msgQueued
+	msgProcessing
+	msgSending
+	msgSendFailed
+	msgVoiceForbidden
+	msgRestarting
+	msgRestartAborted
+	msgRateLimited
+	msgVideoFallback
+	msgURLFailed
+	msgURLUnsafe
+	msgCurrentQuality
+	msgQualityUsage
+	msgQualitySet
+	msgPickSize
+	msgSizePicked
+	msgPickerExpired
+	msgPickerNotOwner
+	msgDownloadIncomplete
+	msgAlbumProgress
+	msgAlbumDone
+	msgResolutionTooHigh
+	msgProcessTimeout
+	msgPreviewUsage
+	msgPreviewCaption
+	msgEditIgnored
+	msgFeedbackUsage
+	msgFeedbackSent
+	msgFeedbackUnavailable
+	msgOutputTooLarge
+	msgQuietUsage
+	msgQuietOn
+	msgQuietOff
+	msgAbout
+	msgPositionNone
+	msgPositionRunning
+	msgPositionNext
+	msgPositionAhead
+	msgPositionWait
+	msgNoteTooLarge
+	msgInvalidOffset
+	msgOffsetTooLate
+	msgQueueFull
+	msgWatermarkUsage
+	msgWatermarkSet
+	msgWatermarkOff
+	msgWatermarkInvalid
+	msgMultiUsage
+	msgProcessTooDemanding
+	msgProcessingETA
+	msgInvalidCrop
+	msgCropOutOfFrame
+	msgOutOfStorage
+	msgSettings
+	msgSettingOn
+	msgSettingOff
+	msgSettingDefault
+	msgSettingAskSize
+	msgSettingsReset
+	msgSettingsResetButton
+	msgSettingsUsage
+	msgInvalidQualityOption
+	msgInvalidFitOption
+	msgOptionsApplied
+	msgNotAuthorized
+	msgConvertLastExpired
+	msgInvalidTargetOption
+	msgTargetUnavailable
+	msgTargetSendFailed
+	msgTargetPosted
+)
+
+// catalog holds the user-facing strings per language. Format verbs must
+// appear in the same order in every translation.
+var catalog = map[string]map[messageKey]string{
+	"en": {
+		msgSendVideo: "Please send a video file to make it circular.",
+		msgHelp: "Hi! I turn your videos into circular video notes.\n\n" +
+			"Send me a video (as a video, a document or a direct link) and I'll reply with a round note. A caption made up only of options applies them to that video alone: from=MM:SS starts later in the video, crop=X:Y picks which part of a wide video goes in the circle (fractions like crop=0.0:0.5 or pixel offsets like crop=120:0), size=, quality= and fit= override your settings, and target=@channel posts the note to a channel you and the bot both administer. Send a round note and I'll turn it back into a regular video.\n\n" +
+			"Limits: files up to %d MB. Common formats such as MP4, MOV, WebM, MKV and GIF are supported.\n\n" +
+			"Commands:\n" +
+			"/setsize <%d-%d> - always use this diameter instead of asking\n" +
+			"/fit [on|off] - keep the whole frame over a blurred background instead of cropping\n" +
+			"/quality <low|medium|high> - trade file size for picture quality\n" +
+			"/preview - reply to a video to see a still of how it will be framed\n" +
+			"/multi [sizes] - reply to a video to get notes in several sizes\n" +
+			"/convertlast [options] - convert your last video again, e.g. /convertlast size=480\n" +
+			"/quiet [on|off] - hide progress messages\n" +
+			"/watermark [off] - reply to a picture to put it on your notes\n" +
+			"/settings [reset] - show your settings or reset them to the defaults\n" +
+			"/position - show your place in the queue\n" +
+			"/cancel - stop the current conversion\n" +
+			"/feedback <text> - report a problem to the bot's operator\n" +
+			"/about - show the bot version\n" +
+			"/help - show this message",
+		msgNothingToCancel: "Nothing to cancel.",
+		msgCancelled:       "Conversion cancelled.",
+		msgFitUsage:        "Usage: /fit [on|off]",
+		msgFitOn:           "Fit mode on: the whole frame will be kept over a blurred background.",
+		msgFitOff:          "Fit mode off: videos will be cropped to the centre.",
+		msgCurrentSize:     "Current video size is %d. Usage: /setsize <%d-%d>",
+		msgInvalidSize:     "Size must be a number between %d and %d divisible by %d.",
+		msgSizeSet:         "Video size set to %d.",
+		msgInvalidVideo:    "Please send a valid video file.",
+		msgNotVideo:        "This file doesn't contain a video. Please send a video file.",
+		msgTooLarge:        "The file is too large. Please send a video smaller than %d MB.",
+		msgTelegramTooBig: "This file is larger than the 20 MB Telegram allows bots to download. " +
+			"Please compress the video or send a shorter clip.",
+		msgDownloadFailed: "Failed to download the video. Please try again.",
+		msgProcessFailed:  "Failed to process the video. Please try again.",
+		msgTrimmed:        "Your video is longer than %d seconds, so it will be trimmed.",
+		msgQueued:         "The bot is busy right now, you're in the queue. Your video will be processed shortly.",
+		msgProcessing:     "Video downloaded. Processing...",
+		msgSending:        "Video processed. Sending...",
+		msgSendFailed:     "Failed to send the processed video. Please try again.",
+		msgVoiceForbidden: "It seems that I don't have permission to send video notes. " +
+			"Please check if you allow sending voice messages in the settings.",
+		msgRestarting:     "The bot is restarting. I'll try to finish your video before going offline.",
+		msgRestartAborted: "The bot is restarting and couldn't finish your video. It will be processed again once the bot is back.",
+		msgRateLimited:    "You're sending videos too fast. Please slow down and try again in %d seconds.",
+		msgVideoFallback: "I'm not allowed to send you video notes, so here is the square video instead. " +
+			"Allow voice messages in your privacy settings to get round notes.",
+		msgURLFailed: "Couldn't download a video from that link. Please check it and try again.",
+		msgURLUnsafe: "That link points to an address I'm not allowed to access.",
+		msgCurrentQuality: "Current quality is %s. Usage: /quality <low|medium|high>\n" +
+			"low - smallest files, medium - balanced, high - best picture, largest files.",
+		msgQualityUsage:         "Usage: /quality <low|medium|high>",
+		msgQualitySet:           "Quality set to %s.",
+		msgPickSize:             "Which size should the note be?",
+		msgSizePicked:           "Making a %d px note.",
+		msgPickerExpired:        "This video has expired, please send it again.",
+		msgPickerNotOwner:       "Only the person who sent the video can pick its size.",
+		msgDownloadIncomplete:   "The download from Telegram was incomplete. Please send the video again.",
+		msgAlbumProgress:        "Processing video %d of %d...",
+		msgAlbumDone:            "Album finished: %d videos processed.",
+		msgResolutionTooHigh:    "This video's resolution is too high. Please send a video no larger than %d px on its longer side.",
+		msgProcessTimeout:       "Your video took too long to process. Please try a shorter or smaller clip.",
+		msgPreviewUsage:         "Reply to a video with /preview to see how it will be framed.",
+		msgPreviewCaption:       "This is how your note will be framed. Send the video again to convert it.",
+		msgEditIgnored:          "Edited messages aren't converted again. Send the video as a new message to get a new note.",
+		msgFeedbackUsage:        "Usage: /feedback <your message>",
+		msgFeedbackSent:         "Thanks! Your feedback has been sent.",
+		msgFeedbackUnavailable:  "Sorry, feedback can't be delivered right now.",
+		msgOutputTooLarge:       "The converted video is too large to send even after compressing it. Please send a shorter clip.",
+		msgQuietUsage:           "Usage: /quiet [on|off]",
+		msgQuietOn:              "Quiet mode on: you'll only get the finished note or an error.",
+		msgQuietOff:             "Quiet mode off: you'll see progress messages while your video is processed.",
+		msgAbout:                "Circles bot version %s (commit %s, built %s).",
+		msgPositionNone:         "You have no videos waiting.",
+		msgPositionRunning:      "Your video is being processed now.",
+		msgPositionNext:         "You're next.",
+		msgPositionAhead:        "%d ahead of you.",
+		msgPositionWait:         "Estimated wait: about %s.",
+		msgNoteTooLarge:         "The resulting note is too large for Telegram. Try a shorter clip or /quality low.",
+		msgInvalidOffset:        "Couldn't read the start time. Use from=SS, from=MM:SS or from=HH:MM:SS in the caption, e.g. from=1:30.",
+		msgOffsetTooLate:        "The start time is past the end of the video, which is %d seconds long.",
+		msgQueueFull:            "The bot is overloaded right now and can't take more videos. Please try again in a few minutes.",
+		msgWatermarkUsage:       "Reply to a picture with /watermark to put it on your notes, or send /watermark off to remove it.",
+		msgWatermarkSet:         "Watermark set. It will appear at the bottom of your notes.",
+		msgWatermarkOff:         "Watermark removed.",
+		msgWatermarkInvalid:     "That picture can't be used as a watermark. Please send a PNG or JPEG image.",
+		msgMultiUsage:           "Reply to a video with /multi [sizes] to get a note in each size, e.g. /multi 384 640. Up to %d sizes between %d and %d, divisible by %d.",
+		msgProcessTooDemanding:  "This video was too demanding to process. Please try a shorter or lower-resolution clip.",
+		msgProcessingETA:        "This should take about %d seconds.",
+		msgInvalidCrop:          "Couldn't read the crop position. Use crop=X:Y with fractions from 0.0 to 1.0, e.g. crop=0.0:0.5, or pixel offsets, e.g. crop=120:0.",
+		msgCropOutOfFrame:       "That crop position is outside the frame. Pixel offsets can be at most %d across and %d down for this video.",
+		msgOutOfStorage:         "The server is temporarily out of storage. Please try again later.",
+		msgSettings:             "Your settings:\nSize: %s\nFit whole frame: %s\nQuality: %s\nQuiet mode: %s\nWatermark: %s",
+		msgSettingOn:            "on",
+		msgSettingOff:           "off",
+		msgSettingDefault:       "%s (default)",
+		msgSettingAskSize:       "ask for each video",
+		msgSettingsReset:        "All settings are back to their defaults.",
+		msgSettingsResetButton:  "Reset to defaults",
+		msgSettingsUsage:        "Usage: /settings [reset]",
+		msgInvalidQualityOption: "quality= must be low, medium or high.",
+		msgInvalidFitOption:     "fit= must be on or off.",
+		msgOptionsApplied:       "Using for this video: %s",
+		msgNotAuthorized:        "Sorry, this bot is private and not available in this chat.",
+		msgConvertLastExpired:   "I don't have your last video any more; videos are kept for %d minutes. Please send it again.",
+		msgInvalidTargetOption:  "target= must be a public @username or a chat ID.",
+		msgTargetUnavailable:    "I can't post to %s: both you and I need to be admins there, and I need permission to post. I'll reply here instead.",
+		msgTargetSendFailed:     "Posting to the target chat failed, so here is your note instead.",
+		msgTargetPosted:         "Posted your note to the target chat.",
+	},
+	"ru": {
+		msgSendVideo: "Пришлите видеофайл, и я сделаю из него кружок.",
+		msgHelp: "Привет! Я превращаю видео в круглые видеосообщения.\n\n" +
+			"Пришлите видео (видеофайлом, документом или прямой ссылкой), и я отвечу кружком. Подпись, состоящая только из параметров, применяет их только к этому видео: from=ММ:СС начинает с нужного места, crop=X:Y выбирает, какая часть широкого видео попадёт в кружок (доли вроде crop=0.0:0.5 или смещение в пикселях вроде crop=120:0), size=, quality= и fit= заменяют ваши настройки, а target=@канал публикует кружок в канале, где вы и бот — администраторы. Пришлите кружок, и я превращу его обратно в обычное видео.\n\n" +
+			"Ограничения: файлы до %d МБ. Поддерживаются распространённые форматы: MP4, MOV, WebM, MKV и GIF.\n\n" +
+			"Команды:\n" +
+			"/setsize <%d-%d> - всегда использовать этот диаметр, не спрашивая\n" +
+			"/fit [on|off] - сохранять кадр целиком на размытом фоне вместо обрезки\n" +
+			"/quality <low|medium|high> - выбрать баланс между размером файла и качеством\n" +
+			"/preview - ответьте на видео, чтобы увидеть кадр будущего кружка\n" +
+			"/multi [размеры] - ответьте на видео, чтобы получить кружки нескольких размеров\n" +
+			"/convertlast [параметры] - переделать последнее видео, например /convertlast size=480\n" +
+			"/quiet [on|off] - скрыть сообщения о ходе обработки\n" +
+			"/watermark [off] - ответьте на картинку, чтобы добавить её на кружки\n" +
+			"/settings [reset] - показать настройки или сбросить их\n" +
+			"/position - показать место в очереди\n" +
+			"/cancel - остановить текущую обработку\n" +
+			"/feedback <текст> - сообщить о проблеме владельцу бота\n" +
+			"/about - показать версию бота\n" +
+			"/help - показать это сообщение",
+		msgNothingToCancel: "Нечего отменять.",
+		msgCancelled:       "Обработка отменена.",
+		msgFitUsage:        "Использование: /fit [on|off]",
+		msgFitOn:           "Режим fit включён: кадр целиком будет помещён на размытый фон.",
+		msgFitOff:          "Режим fit выключен: видео будет обрезано по центру.",
+		msgCurrentSize:     "Текущий размер видео: %d. Использование: /setsize <%d-%d>",
+		msgInvalidSize:     "Размер должен быть числом от %d до %d, кратным %d.",
+		msgSizeSet:         "Размер видео установлен: %d.",
+		msgInvalidVideo:    "Пожалуйста, пришлите корректный видеофайл.",
+		msgNotVideo:        "В этом файле нет видео. Пожалуйста, пришлите видеофайл.",
+		msgTooLarge:        "Файл слишком большой. Пришлите видео размером меньше %d МБ.",
+		msgTelegramTooBig: "Файл больше 20 МБ, которые Telegram разрешает скачивать ботам. " +
+			"Сожмите видео или пришлите более короткий фрагмент.",
+		msgDownloadFailed: "Не удалось скачать видео. Попробуйте ещё раз.",
+		msgProcessFailed:  "Не удалось обработать видео. Попробуйте ещё раз.",
+		msgTrimmed:        "Видео длиннее %d секунд, поэтому оно будет обрезано.",
+		msgQueued:         "Бот сейчас занят, вы в очереди. Скоро ваше видео будет обработано.",
+		msgProcessing:     "Видео скачано. Обрабатываю...",
+		msgSending:        "Видео обработано. Отправляю...",
+		msgSendFailed:     "Не удалось отправить обработанное видео. Попробуйте ещё раз.",
+		msgVoiceForbidden: "Похоже, у меня нет разрешения отправлять видеосообщения. " +
+			"Проверьте, разрешены ли голосовые сообщения в настройках.",
+		msgRestarting:     "Бот перезапускается. Постараюсь закончить ваше видео до отключения.",
+		msgRestartAborted: "Бот перезапускается и не успел обработать ваше видео. Оно будет обработано заново, когда бот вернётся.",
+		msgRateLimited:    "Вы отправляете видео слишком часто. Попробуйте снова через %d секунд.",
+		msgVideoFallback: "Мне запрещено отправлять вам видеосообщения, поэтому вот квадратное видео. " +
+			"Разрешите голосовые сообщения в настройках конфиденциальности, чтобы получать кружки.",
+		msgURLFailed: "Не удалось скачать видео по этой ссылке. Проверьте её и попробуйте ещё раз.",
+		msgURLUnsafe: "Эта ссылка ведёт на адрес, к которому у меня нет доступа.",
+		msgCurrentQuality: "Текущее качество: %s. Использование: /quality <low|medium|high>\n" +
+			"low - самые маленькие файлы, medium - баланс, high - лучшее качество и самые большие файлы.",
+		msgQualityUsage:         "Использование: /quality <low|medium|high>",
+		msgQualitySet:           "Качество установлено: %s.",
+		msgPickSize:             "Какого размера сделать кружок?",
+		msgSizePicked:           "Делаю кружок размером %d пикселей.",
+		msgPickerExpired:        "Это видео устарело, пришлите его снова.",
+		msgPickerNotOwner:       "Выбрать размер может только тот, кто прислал видео.",
+		msgDownloadIncomplete:   "Видео скачалось из Telegram не полностью. Пришлите его снова.",
+		msgAlbumProgress:        "Обрабатываю видео %d из %d...",
+		msgAlbumDone:            "Альбом готов: обработано видео: %d.",
+		msgResolutionTooHigh:    "Слишком высокое разрешение. Пришлите видео не больше %d пикселей по длинной стороне.",
+		msgProcessTimeout:       "Обработка видео заняла слишком много времени. Попробуйте более короткий или лёгкий ролик.",
+		msgPreviewUsage:         "Ответьте на видео командой /preview, чтобы увидеть, как оно будет обрезано.",
+		msgPreviewCaption:       "Так будет выглядеть ваш кружок. Пришлите видео снова, чтобы его конвертировать.",
+		msgEditIgnored:          "Отредактированные сообщения не обрабатываются повторно. Пришлите видео новым сообщением, чтобы получить новый кружок.",
+		msgFeedbackUsage:        "Использование: /feedback <ваше сообщение>",
+		msgFeedbackSent:         "Спасибо! Ваш отзыв отправлен.",
+		msgFeedbackUnavailable:  "К сожалению, сейчас не получается отправить отзыв.",
+		msgOutputTooLarge:       "Даже после сжатия видео получилось слишком большим для отправки. Пришлите более короткий фрагмент.",
+		msgQuietUsage:           "Использование: /quiet [on|off]",
+		msgQuietOn:              "Тихий режим включён: вы получите только готовый кружок или сообщение об ошибке.",
+		msgQuietOff:             "Тихий режим выключен: во время обработки будут приходить сообщения о ходе работы.",
+		msgAbout:                "Бот Circles, версия %s (коммит %s, сборка %s).",
+		msgPositionNone:         "У вас нет видео в очереди.",
+		msgPositionRunning:      "Ваше видео уже обрабатывается.",
+		msgPositionNext:         "Вы следующий.",
+		msgPositionAhead:        "Перед вами в очереди: %d.",
+		msgPositionWait:         "Примерное ожидание: %s.",
+		msgNoteTooLarge:         "Получившийся кружок слишком большой для Telegram. Попробуйте клип покороче или /quality low.",
+		msgInvalidOffset:        "Не удалось разобрать время начала. Укажите в подписи from=СС, from=ММ:СС или from=ЧЧ:ММ:СС, например from=1:30.",
+		msgOffsetTooLate:        "Время начала позже конца видео, его длина %d секунд.",
+		msgQueueFull:            "Бот сейчас перегружен и не может принять новые видео. Попробуйте через несколько минут.",
+		msgWatermarkUsage:       "Ответьте на картинку командой /watermark, чтобы добавить её на кружки, или отправьте /watermark off, чтобы убрать.",
+		msgWatermarkSet:         "Водяной знак установлен. Он появится внизу ваших кружков.",
+		msgWatermarkOff:         "Водяной знак удалён.",
+		msgWatermarkInvalid:     "Эту картинку нельзя использовать как водяной знак. Пришлите изображение PNG или JPEG.",
+		msgMultiUsage:           "Ответьте на видео командой /multi [размеры], чтобы получить кружок каждого размера, например /multi 384 640. До %d размеров от %d до %d, кратных %d.",
+		msgProcessTooDemanding:  "Это видео слишком тяжёлое для обработки. Попробуйте клип покороче или с меньшим разрешением.",
+		msgProcessingETA:        "Это займёт около %d секунд.",
+		msgInvalidCrop:          "Не удалось разобрать положение кадра. Укажите crop=X:Y долями от 0.0 до 1.0, например crop=0.0:0.5, или смещением в пикселях, например crop=120:0.",
+		msgCropOutOfFrame:       "Это положение выходит за кадр. Для этого видео смещение может быть не больше %d по горизонтали и %d по вертикали.",
+		msgOutOfStorage:         "На сервере временно закончилось место. Попробуйте позже.",
+		msgSettings:             "Ваши настройки:\nРазмер: %s\nВесь кадр: %s\nКачество: %s\nТихий режим: %s\nВодяной знак: %s",
+		msgSettingOn:            "вкл",
+		msgSettingOff:           "выкл",
+		msgSettingDefault:       "%s (по умолчанию)",
+		msgSettingAskSize:       "спрашивать для каждого видео",
+		msgSettingsReset:        "Все настройки сброшены к значениям по умолчанию.",
+		msgSettingsResetButton:  "Сбросить настройки",
+		msgSettingsUsage:        "Использование: /settings [reset]",
+		msgInvalidQualityOption: "quality= может быть low, medium или high.",
+		msgInvalidFitOption:     "fit= может быть on или off.",
+		msgOptionsApplied:       "Для этого видео: %s",
+		msgNotAuthorized:        "Извините, это частный бот, и в этом чате он недоступен.",
+		msgConvertLastExpired:   "Вашего последнего видео у меня уже нет: видео хранятся %d минут. Пришлите его ещё раз.",
+		msgInvalidTargetOption:  "target= должен быть публичным @именем или ID чата.",
+		msgTargetUnavailable:    "Не могу опубликовать в %s: и вы, и я должны быть там администраторами, и мне нужно право публикации. Отвечу здесь.",
+		msgTargetSendFailed:     "Не удалось опубликовать в указанный чат, так что вот ваш кружок.",
+		msgTargetPosted:         "Кружок опубликован в указанном чате.",
+	},
+	"es": {
+		msgSendVideo: "Envía un archivo de vídeo para convertirlo en circular.",
+		msgHelp: "¡Hola! Convierto tus vídeos en notas de vídeo circulares.\n\n" +
+			"Envíame un vídeo (como vídeo, documento o enlace directo) y te responderé con una nota redonda. Un pie formado solo por opciones las aplica solo a ese vídeo: from=MM:SS empieza más adelante, crop=X:Y elige qué parte de un vídeo ancho entra en el círculo (fracciones como crop=0.0:0.5 o píxeles como crop=120:0), size=, quality= y fit= sustituyen tus ajustes, y target=@canal publica la nota en un canal que administráis tú y el bot. Envíame una nota redonda y la convertiré de nuevo en un vídeo normal.\n\n" +
+			"Límites: archivos de hasta %d MB. Se admiten formatos comunes como MP4, MOV, WebM, MKV y GIF.\n\n" +
+			"Comandos:\n" +
+			"/setsize <%d-%d> - usar siempre este diámetro sin preguntar\n" +
+			"/fit [on|off] - mantener el encuadre completo sobre un fondo difuminado en lugar de recortar\n" +
+			"/quality <low|medium|high> - equilibrar tamaño de archivo y calidad de imagen\n" +
+			"/preview - responde a un vídeo para ver un fotograma de cómo quedará\n" +
+			"/multi [tamaños] - responde a un vídeo para recibir notas de varios tamaños\n" +
+			"/convertlast [opciones] - convertir de nuevo tu último vídeo, p. ej. /convertlast size=480\n" +
+			"/quiet [on|off] - ocultar los mensajes de progreso\n" +
+			"/watermark [off] - responde a una imagen para ponerla en tus notas\n" +
+			"/settings [reset] - ver tus ajustes o restablecerlos\n" +
+			"/position - ver tu lugar en la cola\n" +
+			"/cancel - detener la conversión actual\n" +
+			"/feedback <texto> - informar de un problema al operador del bot\n" +
+			"/about - mostrar la versión del bot\n" +
+			"/help - mostrar este mensaje",
+		msgNothingToCancel: "No hay nada que cancelar.",
+		msgCancelled:       "Conversión cancelada.",
+		msgFitUsage:        "Uso: /fit [on|off]",
+		msgFitOn:           "Modo fit activado: se mantendrá el encuadre completo sobre un fondo difuminado.",
+		msgFitOff:          "Modo fit desactivado: los vídeos se recortarán al centro.",
+		msgCurrentSize:     "El tamaño actual del vídeo es %d. Uso: /setsize <%d-%d>",
+		msgInvalidSize:     "El tamaño debe ser un número entre %d y %d divisible por %d.",
+		msgSizeSet:         "Tamaño del vídeo establecido en %d.",
+		msgInvalidVideo:    "Envía un archivo de vídeo válido.",
+		msgNotVideo:        "Este archivo no contiene vídeo. Envía un archivo de vídeo.",
+		msgTooLarge:        "El archivo es demasiado grande. Envía un vídeo de menos de %d MB.",
+		msgTelegramTooBig: "Este archivo supera los 20 MB que Telegram permite descargar a los bots. " +
+			"Comprime el vídeo o envía un fragmento más corto.",
+		msgDownloadFailed: "No se pudo descargar el vídeo. Inténtalo de nuevo.",
+		msgProcessFailed:  "No se pudo procesar el vídeo. Inténtalo de nuevo.",
+		msgTrimmed:        "Tu vídeo dura más de %d segundos, así que se recortará.",
+		msgQueued:         "El bot está ocupado ahora mismo, estás en la cola. Tu vídeo se procesará en breve.",
+		msgProcessing:     "Vídeo descargado. Procesando...",
+		msgSending:        "Vídeo procesado. Enviando...",
+		msgSendFailed:     "No se pudo enviar el vídeo procesado. Inténtalo de nuevo.",
+		msgVoiceForbidden: "Parece que no tengo permiso para enviar notas de vídeo. " +
+			"Comprueba si permites los mensajes de voz en la configuración.",
+		msgRestarting:     "El bot se está reiniciando. Intentaré terminar tu vídeo antes de desconectarme.",
+		msgRestartAborted: "El bot se está reiniciando y no pudo terminar tu vídeo. Se procesará de nuevo cuando el bot vuelva.",
+		msgRateLimited:    "Estás enviando vídeos demasiado rápido. Espera un poco e inténtalo de nuevo en %d segundos.",
+		msgVideoFallback: "No puedo enviarte notas de vídeo, así que aquí tienes el vídeo cuadrado. " +
+			"Permite los mensajes de voz en tu configuración de privacidad para recibir notas redondas.",
+		msgURLFailed: "No se pudo descargar un vídeo desde ese enlace. Compruébalo e inténtalo de nuevo.",
+		msgURLUnsafe: "Ese enlace apunta a una dirección a la que no tengo permitido acceder.",
+		msgCurrentQuality: "La calidad actual es %s. Uso: /quality <low|medium|high>\n" +
+			"low - archivos más pequeños, medium - equilibrado, high - mejor imagen y archivos más grandes.",
+		msgQualityUsage:         "Uso: /quality <low|medium|high>",
+		msgQualitySet:           "Calidad establecida en %s.",
+		msgPickSize:             "¿De qué tamaño quieres la nota?",
+		msgSizePicked:           "Creando una nota de %d px.",
+		msgPickerExpired:        "Este vídeo ha caducado, vuelve a enviarlo.",
+		msgPickerNotOwner:       "Solo quien envió el vídeo puede elegir su tamaño.",
+		msgDownloadIncomplete:   "La descarga desde Telegram quedó incompleta. Vuelve a enviar el vídeo.",
+		msgAlbumProgress:        "Procesando el vídeo %d de %d...",
+		msgAlbumDone:            "Álbum terminado: %d vídeos procesados.",
+		msgResolutionTooHigh:    "La resolución de este vídeo es demasiado alta. Envía un vídeo de como máximo %d px en su lado más largo.",
+		msgProcessTimeout:       "Tu vídeo tardó demasiado en procesarse. Prueba con un fragmento más corto o más ligero.",
+		msgPreviewUsage:         "Responde a un vídeo con /preview para ver cómo quedará encuadrado.",
+		msgPreviewCaption:       "Así quedará encuadrada tu nota. Vuelve a enviar el vídeo para convertirlo.",
+		msgEditIgnored:          "Los mensajes editados no se vuelven a convertir. Envía el vídeo en un mensaje nuevo para obtener otra nota.",
+		msgFeedbackUsage:        "Uso: /feedback <tu mensaje>",
+		msgFeedbackSent:         "¡Gracias! Tu comentario se ha enviado.",
+		msgFeedbackUnavailable:  "Lo siento, ahora mismo no se pueden enviar comentarios.",
+		msgOutputTooLarge:       "El vídeo convertido es demasiado grande para enviarlo incluso tras comprimirlo. Envía un fragmento más corto.",
+		msgQuietUsage:           "Uso: /quiet [on|off]",
+		msgQuietOn:              "Modo silencioso activado: solo recibirás la nota terminada o un error.",
+		msgQuietOff:             "Modo silencioso desactivado: verás mensajes de progreso mientras se procesa tu vídeo.",
+		msgAbout:                "Bot Circles versión %s (commit %s, compilado %s).",
+		msgPositionNone:         "No tienes vídeos en espera.",
+		msgPositionRunning:      "Tu vídeo se está procesando ahora.",
+		msgPositionNext:         "Eres el siguiente.",
+		msgPositionAhead:        "Hay %d delante de ti.",
+		msgPositionWait:         "Espera estimada: unos %s.",
+		msgNoteTooLarge:         "La nota resultante es demasiado grande para Telegram. Prueba con un clip más corto o /quality low.",
+		msgInvalidOffset:        "No se pudo leer el inicio. Usa from=SS, from=MM:SS o from=HH:MM:SS en el pie, p. ej. from=1:30.",
+		msgOffsetTooLate:        "El inicio está después del final del vídeo, que dura %d segundos.",
+		msgQueueFull:            "El bot está sobrecargado y no puede aceptar más vídeos ahora. Inténtalo de nuevo en unos minutos.",
+		msgWatermarkUsage:       "Responde a una imagen con /watermark para ponerla en tus notas, o envía /watermark off para quitarla.",
+		msgWatermarkSet:         "Marca de agua establecida. Aparecerá en la parte inferior de tus notas.",
+		msgWatermarkOff:         "Marca de agua eliminada.",
+		msgWatermarkInvalid:     "Esa imagen no se puede usar como marca de agua. Envía una imagen PNG o JPEG.",
+		msgMultiUsage:           "Responde a un vídeo con /multi [tamaños] para recibir una nota de cada tamaño, p. ej. /multi 384 640. Hasta %d tamaños entre %d y %d, divisibles por %d.",
+		msgProcessTooDemanding:  "Este vídeo es demasiado exigente para procesarlo. Prueba con un clip más corto o de menor resolución.",
+		msgProcessingETA:        "Debería tardar unos %d segundos.",
+		msgInvalidCrop:          "No se pudo leer la posición del recorte. Usa crop=X:Y con fracciones de 0.0 a 1.0, p. ej. crop=0.0:0.5, o píxeles, p. ej. crop=120:0.",
+		msgCropOutOfFrame:       "Esa posición de recorte queda fuera del encuadre. Para este vídeo el desplazamiento puede ser como mucho %d en horizontal y %d en vertical.",
+		msgOutOfStorage:         "El servidor se ha quedado sin espacio temporalmente. Inténtalo más tarde.",
+		msgSettings:             "Tus ajustes:\nTamaño: %s\nEncuadre completo: %s\nCalidad: %s\nModo silencioso: %s\nMarca de agua: %s",
+		msgSettingOn:            "activado",
+		msgSettingOff:           "desactivado",
+		msgSettingDefault:       "%s (predeterminado)",
+		msgSettingAskSize:       "preguntar en cada vídeo",
+		msgSettingsReset:        "Todos los ajustes han vuelto a sus valores predeterminados.",
+		msgSettingsResetButton:  "Restablecer ajustes",
+		msgSettingsUsage:        "Uso: /settings [reset]",
+		msgInvalidQualityOption: "quality= debe ser low, medium o high.",
+		msgInvalidFitOption:     "fit= debe ser on u off.",
+		msgOptionsApplied:       "Para este vídeo: %s",
+		msgNotAuthorized:        "Lo siento, este bot es privado y no está disponible en este chat.",
+		msgConvertLastExpired:   "Ya no tengo tu último vídeo; los vídeos se guardan %d minutos. Envíalo de nuevo.",
+		msgInvalidTargetOption:  "target= debe ser un @usuario público o un ID de chat.",
+		msgTargetUnavailable:    "No puedo publicar en %s: tú y yo debemos ser administradores allí y necesito permiso para publicar. Te responderé aquí.",
+		msgTargetSendFailed:     "No se pudo publicar en el chat de destino, así que aquí tienes tu nota.",
+		msgTargetPosted:         "Tu nota se ha publicado en el chat de destino.",
+	},
+}
+
+// localize returns the string for key in lang, which may be a full IETF tag
+// such as "es-419", falling back to English.
+func localize(lang string, key messageKey, args ...any) string {
+	lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+
+	text, ok := catalog[lang][key]
+	if !ok {
+		text = catalog[defaultLanguage][key]
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// languageOf returns the sender's client language, if known.
+func languageOf(message *tgbotapi.Message) string {
+	if message.From == nil {
+		return ""
+	}
+	return message.From.LanguageCode
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// inputKind names the part of a message that gets converted.
+type inputKind string
+
+const (
+	inputNone      inputKind = ""
+	inputVideoNote inputKind = "video_note"
+	inputAnimation inputKind = "animation"
+	inputVideo     inputKind = "video"
+	inputDocument  inputKind = "document"
+	inputAudio     inputKind = "audio"
+	inputURL       inputKind = "url"
+)
+
+// messageInput picks what in message to convert. Telegram can fill in
+// several fields at once: animations always come with a Document for older
+// clients, and forwards may combine others. The first present wins:
+//
+//  1. VideoNote, a round note turned back into a regular video
+//  2. Animation, ahead of the Document that accompanies it
+//  3. Video
+//  4. Document
+//  5. Voice or Audio, drawn as a waveform when AUDIO_NOTES is on
+//  6. a link making up the text
+func messageInput(message *tgbotapi.Message) inputKind {
+	switch {
+	case message.VideoNote != nil:
+		return inputVideoNote
+	case message.Animation != nil:
+		return inputAnimation
+	case message.Video != nil:
+		return inputVideo
+	case message.Document != nil:
+		return inputDocument
+	case hasAudio(message):
+		return inputAudio
+	}
+	if _, ok := parseVideoURL(message.Text); ok {
+		return inputURL
+	}
+	return inputNone
+}
+
+// isVideoInput reports whether kind is one of the video inputs
+// processVideo handles.
+func isVideoInput(kind inputKind) bool {
+	return kind == inputAnimation || kind == inputVideo || kind == inputDocument
+}
+
+// logInputChoice records which input was picked when a message carries
+// more than one, apart from the Document every Animation comes with.
+func logInputChoice(logger *slog.Logger, message *tgbotapi.Message, kind inputKind) {
+	var present []inputKind
+	for _, candidate := range []struct {
+		kind inputKind
+		set  bool
+	}{
+		{inputVideoNote, message.VideoNote != nil},
+		{inputAnimation, message.Animation != nil},
+		{inputVideo, message.Video != nil},
+		{inputDocument, message.Document != nil && message.Animation == nil},
+		{inputAudio, hasAudio(message)},
+	} {
+		if candidate.set {
+			present = append(present, candidate.kind)
+		}
+	}
+	if len(present) > 1 {
+		logger.Info("Message has several inputs, converting one", "input", kind, "present", present)
+	} else {
+		logger.Debug("Converting message input", "input", kind)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMessageInput(t *testing.T) {
+	var (
+		videoNote = &tgbotapi.VideoNote{FileID: "note"}
+		animation = &tgbotapi.Animation{FileID: "gif"}
+		video     = &tgbotapi.Video{FileID: "video"}
+		document  = &tgbotapi.Document{FileID: "doc"}
+		voice     = &tgbotapi.Voice{FileID: "voice"}
+		audio     = &tgbotapi.Audio{FileID: "audio"}
+	)
+
+	tests := []struct {
+		name    string
+		message tgbotapi.Message
+		want    inputKind
+	}{
+		{"empty message", tgbotapi.Message{}, inputNone},
+		{"plain text", tgbotapi.Message{Text: "hello"}, inputNone},
+		{"video note", tgbotapi.Message{VideoNote: videoNote}, inputVideoNote},
+		{"animation", tgbotapi.Message{Animation: animation}, inputAnimation},
+		{"video", tgbotapi.Message{Video: video}, inputVideo},
+		{"document", tgbotapi.Message{Document: document}, inputDocument},
+		{"voice", tgbotapi.Message{Voice: voice}, inputAudio},
+		{"audio", tgbotapi.Message{Audio: audio}, inputAudio},
+		{"url", tgbotapi.Message{Text: "https://example.com/clip.mp4"}, inputURL},
+		{"url with mention", tgbotapi.Message{Text: "@circles_bot https://example.com/clip.mp4"}, inputURL},
+		{"url among other words", tgbotapi.Message{Text: "look https://example.com/clip.mp4"}, inputNone},
+		{"non-http url", tgbotapi.Message{Text: "ftp://example.com/clip.mp4"}, inputNone},
+
+		{"animation with its document", tgbotapi.Message{Animation: animation, Document: document}, inputAnimation},
+		{"video note over video", tgbotapi.Message{VideoNote: videoNote, Video: video}, inputVideoNote},
+		{"video over document", tgbotapi.Message{Video: video, Document: document}, inputVideo},
+		{"document over audio", tgbotapi.Message{Document: document, Audio: audio}, inputDocument},
+		{"video over voice", tgbotapi.Message{Video: video, Voice: voice}, inputVideo},
+		{"video over caption url", tgbotapi.Message{Video: video, Text: "https://example.com/clip.mp4"}, inputVideo},
+		{"audio over url", tgbotapi.Message{Audio: audio, Text: "https://example.com/clip.mp4"}, inputAudio},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageInput(&tt.message); got != tt.want {
+				t.Errorf("messageInput() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+type activeJob struct {
+	cancel context.CancelFunc
+	// lang is the requester's language, for messages sent outside the
+	// request flow such as shutdown notices.
+	lang string
+}
+
+// jobRegistry tracks the cancel funcs of in-progress conversions per chat so
+// /cancel can abort them.
+type jobRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[int64]map[uint64]activeJob
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[int64]map[uint64]activeJob)}
+}
+
+// start registers a new job for chatID. The returned func must be called
+// when the job finishes; it releases the context and removes the entry.
+func (r *jobRegistry) start(ctx context.Context, chatID int64, lang string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	if r.jobs[chatID] == nil {
+		r.jobs[chatID] = make(map[uint64]activeJob)
+	}
+	r.jobs[chatID][id] = activeJob{cancel: cancel, lang: lang}
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.jobs[chatID], id)
+		if len(r.jobs[chatID]) == 0 {
+			delete(r.jobs, chatID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// chats returns the chats with at least one job in progress, mapped to the
+// language of their most recent job.
+func (r *jobRegistry) chats() map[int64]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chats := make(map[int64]string, len(r.jobs))
+	for chatID, jobs := range r.jobs {
+		var newest uint64
+		for id, job := range jobs {
+			if id >= newest {
+				newest = id
+				chats[chatID] = job.lang
+			}
+		}
+	}
+	return chats
+}
+
+// cancel aborts every job running for chatID and returns how many there were.
+func (r *jobRegistry) cancel(chatID int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := r.jobs[chatID]
+	for _, job := range jobs {
+		job.cancel()
+	}
+	return len(jobs)
+}
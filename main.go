@@ -1,173 +1,1620 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
-	defaultVideoSize       = 640
-	voiceMsgRestrictionErr = "Bad Request: VOICE_MESSAGES_FORBIDDEN"
+	// maxMessageLength is Telegram's limit for a text message.
+	maxMessageLength = 4096
 )
 
+var errFileTooLarge = errors.New("file exceeds maximum allowed size")
+
+// errIncompleteDownload means the body ended before the size GetFile
+// reported, usually because the connection dropped.
+var errIncompleteDownload = errors.New("download incomplete")
+
+// errOutputTooLarge means the note stayed above MAX_OUTPUT_SIZE even at the
+// most aggressive compression tried.
+var errOutputTooLarge = errors.New("output exceeds maximum size")
+
+// Re-encoding passes for outputs above MAX_OUTPUT_SIZE: each raises the CRF
+// by compressionCRFStep, up to libx264's maximum of 51.
+const (
+	compressionPasses  = 3
+	compressionCRFStep = 5
+	maxCRF             = 51
+)
+
+// errFFmpegTimeout means ffmpeg was killed for running past FFMPEG_TIMEOUT.
+var errFFmpegTimeout = errors.New("ffmpeg timed out")
+
+// ffmpegWaitDelay bounds how long Wait blocks on ffmpeg's pipes after the
+// process has been killed.
+const ffmpegWaitDelay = 5 * time.Second
+
+const downloadTimeout = 5 * time.Minute
+
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// minAnimationDuration is how long short GIFs are looped to, so the note
+// doesn't flash by.
+const minAnimationDuration = 3 * time.Second
+
+type audioMode int
+
+const (
+	// audioEncode re-encodes to AAC or Opus, depending on the output
+	// format; it is the zero value so an unprobed
+	// input still gets a track Telegram can play.
+	audioEncode audioMode = iota
+	audioCopy
+	audioNone
+)
+
+const audioBitrate = "128k"
+
+// videoOptions controls how makeCircularVideo encodes a single note.
+type videoOptions struct {
+	Size        int
+	MaxDuration time.Duration
+	Audio       audioMode
+	// Loop repeats the input until MaxDuration is reached.
+	Loop    bool
+	Fit     bool
+	Encoder encoderSettings
+	// Rotation is the clockwise turn, in degrees, that makes the input
+	// upright. When set, ffmpeg's own autorotation is disabled and the turn
+	// is done in the filtergraph instead.
+	Rotation int
+	// Letterbox turns a square note back into a 16:9 video instead of
+	// making a note; Size and Fit are ignored.
+	Letterbox bool
+	// Start skips this much of the input before encoding.
+	Start time.Duration
+	// Crop is where the square is cut from a non-square frame.
+	Crop cropPosition
+	// Square means the input frame is already square, so it is scaled
+	// without cropping.
+	Square bool
+	// CopyVideo passes the input's video stream through untouched; see
+	// canCopyVideo.
+	CopyVideo bool
+	// Waveform draws the input's audio as an animated waveform instead of
+	// using its video, which it need not have.
+	Waveform bool
+	// Format is the container and codecs written.
+	Format outputFormat
+	// Watermark, if set, is the path of an image overlaid near the bottom
+	// of the note.
+	Watermark string
+	// FrameRate, if set, caps the output frame rate; it is only set for
+	// sources faster than MAX_FPS.
+	FrameRate int
+	// Length is how long the output is expected to be, for progress
+	// reports; 0 if unknown.
+	Length time.Duration
+	// Lenient decodes past errors in the input and regenerates missing
+	// timestamps, for a second attempt at slightly corrupt files.
+	Lenient bool
+	// MinDuration, if set, is how long an input known to be shorter is
+	// looped to; see needsPadding.
+	MinDuration time.Duration
+}
+
+// needsPadding reports whether the input, opts.Length long, falls short of
+// MinDuration and is looped up to it. Inputs already looped, such as short
+// GIFs stretched to minAnimationDuration, are left alone.
+func (opts videoOptions) needsPadding() bool {
+	return !opts.Loop && opts.Length > 0 && opts.Length < opts.MinDuration
+}
+
+type app struct {
+	bot      *telegramClient
+	cfg      config
+	settings SettingsStore
+
+	// jobs is a semaphore bounding the number of concurrent ffmpeg runs.
+	jobs chan struct{}
+
+	active  *jobRegistry
+	stats   *stats
+	limiter *rateLimiter
+	cache   *outputCache
+	pending *pendingInputs
+	// lastInputs keeps each chat's last video for /convertlast.
+	lastInputs *lastInputs
+	albums     *albumCollector
+	chats      *chatSet
+	// termsSeen holds the chats that have been shown TermsNotice.
+	termsSeen *chatSet
+	queue     *jobQueue
+	metrics   *metrics
+	dedup     *updateDeduper
+	// storage archives delivered notes; nil unless ARCHIVE_STORAGE is set.
+	storage Storage
+	// storageAlert throttles out-of-disk alerts to the admin chat.
+	storageAlert storageAlert
+
+	// transcode runs one ffmpeg encode; it is makeCircularVideo except
+	// where a stand-in is needed, e.g. to exercise the handlers without
+	// ffmpeg.
+	transcode transcoder
+
+	// inflight counts running jobs and pending albums.
+	inflight sync.WaitGroup
+
+	startedAt  time.Time
+	webhookSet atomic.Bool
+}
+
 func main() {
+	setupLogger()
+
+	cfg := loadConfig()
+
 	botToken := os.Getenv("BOT_TOKEN")
-	if botToken == "" {
-		log.Fatal("BOT_TOKEN environment variable is not set")
+	if botToken == "" && !cfg.DryRun {
+		fatal("BOT_TOKEN environment variable is not set")
 	}
 
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	slog.Info("Using video size", "size", cfg.VideoSize)
+
+	ffmpegPath, ffprobePath = cfg.FFmpegPath, cfg.FFprobePath
+	for _, tool := range []string{ffmpegPath, ffprobePath} {
+		version, err := toolVersion(tool)
+		if err != nil {
+			fatal("Required tool is unavailable, install ffmpeg or set FFMPEG_PATH and FFPROBE_PATH", "tool", tool, "err", err)
+		}
+		slog.Info("Found "+filepath.Base(tool), "path", tool, "version", version)
+	}
+
+	if err := prepareWorkDir(cfg.WorkDir); err != nil {
+		fatal("Work directory is not usable", "dir", cfg.WorkDir, "err", err)
+	}
+	if n := cleanupTempFiles(cfg.WorkDir, cfg.TempFileMaxAge); n > 0 {
+		slog.Info("Removed stale temp files", "count", n)
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		fatal("Error creating data directory", "dir", cfg.DataDir, "err", err)
+	}
+	chats, err := loadChatSet(filepath.Join(cfg.DataDir, "chats.json"))
 	if err != nil {
-		log.Panic(err)
+		fatal("Error loading chat list", "err", err)
 	}
 
-	bot.Debug = true
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	termsSeen, err := loadChatSet(filepath.Join(cfg.DataDir, "terms_seen.json"))
+	if err != nil {
+		fatal("Error loading terms notice list", "err", err)
+	}
+
+	queue, err := loadJobQueue(filepath.Join(cfg.DataDir, "jobs.json"), cfg.MaxQueue)
+	if err != nil {
+		fatal("Error loading job queue", "err", err)
+	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	settings, err := newSettingsBackend(cfg.SettingsBackend, filepath.Join(cfg.DataDir, "settings.json"))
+	if err != nil {
+		fatal("Error loading settings", "err", err)
+	}
+
+	storage, err := newStorage(cfg)
+	if err != nil {
+		fatal("Error setting up archive storage", "backend", cfg.ArchiveBackend, "err", err)
+	}
+
+	if cfg.DryRun {
+		slog.Info("Dry run: configuration is valid, exiting", "config", cfg.summary(), "bot_token_set", botToken != "")
+		return
+	}
 
-	updates := bot.GetUpdatesChan(u)
+	api, err := tgbotapi.NewBotAPI(botToken)
+	if err != nil {
+		fatal("Error creating bot", "err", err)
+	}
+	m := newMetrics()
 
+	// ctx is cancelled on the shutdown signal.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	bot := &telegramClient{BotAPI: api, retry: cfg.Retry, metrics: m, stop: ctx}
+
+	// Route the library's request/response dumps to debug level.
+	tgbotapi.SetLogger(slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug))
+	bot.Debug = true
+	slog.Info("Authorized on account", "username", bot.Self.UserName)
+
+	a := &app{
+		bot:        bot,
+		cfg:        cfg,
+		settings:   settings,
+		jobs:       make(chan struct{}, cfg.MaxConcurrentJobs),
+		active:     newJobRegistry(),
+		stats:      newStats(),
+		limiter:    newRateLimiter(cfg.RateLimit, cfg.RateLimitWindow),
+		cache:      newOutputCache(cfg.CacheSize, cfg.CacheTTL),
+		pending:    newPendingInputs(),
+		lastInputs: newLastInputs(),
+		chats:      chats,
+		termsSeen:  termsSeen,
+		queue:      queue,
+		metrics:    m,
+		dedup:      newUpdateDeduper(cfg.DedupWindow),
+		storage:    storage,
+
+		transcode: makeCircularVideo,
+
+		startedAt: time.Now(),
+	}
+
+	a.albums = newAlbumCollector(albumWindow, &a.inflight, a.enqueueAlbum)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.HealthPath, a.handleHealth)
+	mux.Handle(cfg.MetricsPath, m.handler())
+
+	updates := startUpdates(bot, cfg, mux)
+	a.webhookSet.Store(cfg.Mode == modeWebhook)
+
+	var server *http.Server
+	if cfg.ListenAddr != "" {
+		server = serveHTTP(cfg.ListenAddr, mux)
+	}
+
+	// Conversions get their own context so a shutdown signal lets them
+	// finish instead of killing ffmpeg straight away.
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// Workers stop taking jobs as soon as the shutdown signal arrives.
+	a.startWorkers(ctx, jobCtx, cfg.Workers)
+	go sweepTempFiles(ctx, cfg.WorkDir, cfg.TempFileMaxAge)
+
 	// Set up graceful shutdown
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
-		log.Println("Received shutdown signal. Closing bot...")
+		slog.Info("Received shutdown signal. Closing bot...")
 		cancel()
 	}()
 
 	for {
 		select {
 		case update := <-updates:
+			if a.dedup.duplicate(update.UpdateID) {
+				slog.Info("Ignoring duplicate update", "update_id", update.UpdateID)
+				continue
+			}
+			if chat := update.FromChat(); chat != nil && !a.chatAllowed(chat.ID) {
+				a.rejectUpdate(jobCtx, update)
+				continue
+			}
+			if update.CallbackQuery != nil {
+				a.handleCallback(jobCtx, update.CallbackQuery)
+				continue
+			}
+			if update.EditedMessage != nil {
+				a.handleEditedMessage(ctx, update.EditedMessage)
+				continue
+			}
 			if update.Message == nil {
 				continue
 			}
 
-			if update.Message.Video != nil || update.Message.Document != nil {
-				go handleVideo(ctx, bot, update.Message)
-			} else {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please send a video file to make it circular.")
-				bot.Send(msg)
-			}
+			a.handleMessage(jobCtx, update.Message)
 		case <-ctx.Done():
-			log.Println("Bot is shutting down...")
+			slog.Info("Bot is shutting down...")
+			a.shutdown(server, cancelJobs)
+			return
+		}
+	}
+}
+
+func (a *app) handleMessage(ctx context.Context, message *tgbotapi.Message) {
+	if _, err := a.chats.add(message.Chat.ID); err != nil {
+		slog.Error("Error saving chat list", "err", err)
+	}
+	a.showTermsOnce(ctx, message)
+
+	if message.IsCommand() {
+		if a.isCommandForOtherBot(message) {
 			return
 		}
+		a.handleCommand(ctx, message)
+		return
+	}
+
+	isGroup := message.Chat.IsGroup() || message.Chat.IsSuperGroup()
+	if isGroup && !a.cfg.GroupConvertAll {
+		if !a.isAddressedToBot(message) {
+			return
+		}
+		// "@bot" in reply to someone else's video or video note converts it.
+		if !hasVideo(message) && message.VideoNote == nil && message.ReplyToMessage != nil &&
+			(hasVideo(message.ReplyToMessage) || message.ReplyToMessage.VideoNote != nil) {
+			message = message.ReplyToMessage
+		}
+	}
+
+	switch kind := messageInput(message); {
+	case isVideoInput(kind) && message.MediaGroupID != "":
+		a.albums.add(ctx, message)
+	case kind == inputNone, kind == inputAudio && !a.cfg.AudioNotes:
+		sendText(ctx, a.bot, message.Chat.ID, localize(languageOf(message), msgSendVideo), textProgress)
+	default:
+		a.enqueueJob(ctx, newJob(message))
+	}
+}
+
+// handleEditedMessage explains that edits aren't picked up. Reprocessing
+// would send a second note for what is usually just a caption fix, so the
+// user is asked to send the video again instead. Groups aren't answered to
+// keep the chat quiet.
+func (a *app) handleEditedMessage(ctx context.Context, message *tgbotapi.Message) {
+	slog.Debug("Ignoring edited message", "chat_id", message.Chat.ID, "message_id", message.MessageID)
+	if !message.Chat.IsPrivate() || (!hasVideo(message) && message.VideoNote == nil) {
+		return
+	}
+	sendText(ctx, a.bot, message.Chat.ID, localize(languageOf(message), msgEditIgnored), textProgress)
+}
+
+func hasVideo(message *tgbotapi.Message) bool {
+	return message.Video != nil || message.Document != nil || message.Animation != nil
+}
+
+// isAddressedToBot reports whether a group message mentions the bot or
+// replies to one of its messages.
+func (a *app) isAddressedToBot(message *tgbotapi.Message) bool {
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == a.bot.Self.ID {
+		return true
+	}
+
+	mention := "@" + strings.ToLower(a.bot.Self.UserName)
+	return strings.Contains(strings.ToLower(message.Text), mention) ||
+		strings.Contains(strings.ToLower(message.Caption), mention)
+}
+
+// isCommandForOtherBot reports whether a command is explicitly addressed to
+// a different bot, as in "/help@otherbot" in a group.
+func (a *app) isCommandForOtherBot(message *tgbotapi.Message) bool {
+	_, target, ok := strings.Cut(message.CommandWithAt(), "@")
+	return ok && !strings.EqualFold(target, a.bot.Self.UserName)
+}
+
+func (a *app) handleCommand(ctx context.Context, message *tgbotapi.Message) {
+	switch message.Command() {
+	case "start", "help":
+		a.handleHelp(ctx, message)
+	case "about":
+		sendText(ctx, a.bot, message.Chat.ID, localize(languageOf(message), msgAbout, version, commit, buildDate), textProgress)
+	case "setsize":
+		a.handleSetSize(ctx, message)
+	case "cancel":
+		a.handleCancel(ctx, message)
+	case "fit":
+		a.handleFit(ctx, message)
+	case "quiet":
+		a.handleQuiet(ctx, message)
+	case "position":
+		a.handlePosition(ctx, message)
+	case "settings":
+		a.handleSettings(ctx, message)
+	case "quality":
+		a.handleQuality(ctx, message)
+	case "feedback":
+		a.handleFeedback(ctx, message)
+	case "convertlast", "multi", "watermark", "preview":
+		// These download and convert, so they wait their turn in the
+		// worker pool like videos do; see runCommand.
+		a.enqueueJob(ctx, newJob(message))
+	case "stats", "broadcast":
+		if a.isAdmin(message.Chat.ID) {
+			a.handleAdminCommand(ctx, message)
+			return
+		}
+		fallthrough
+	default:
+		sendText(ctx, a.bot, message.Chat.ID, localize(languageOf(message), msgSendVideo), textProgress)
+	}
+}
+
+func (a *app) handleHelp(ctx context.Context, message *tgbotapi.Message) {
+	text := localize(languageOf(message), msgHelp, a.cfg.MaxFileSize>>20, minVideoSize, maxVideoSize)
+	sendText(ctx, a.bot, message.Chat.ID, text, textProgress)
+}
+
+func (a *app) isAdmin(chatID int64) bool {
+	return a.cfg.AdminChatID != 0 && chatID == a.cfg.AdminChatID
+}
+
+func (a *app) handleAdminCommand(ctx context.Context, message *tgbotapi.Message) {
+	switch message.Command() {
+	case "stats":
+		a.handleStats(ctx, message)
+	case "broadcast":
+		a.handleBroadcast(ctx, message)
+	}
+}
+
+func (a *app) handleStats(ctx context.Context, message *tgbotapi.Message) {
+	if message.CommandArguments() == "reset" {
+		a.stats.reset()
+		sendText(ctx, a.bot, message.Chat.ID, "Stats reset.", textProgress)
+		return
+	}
+	sendText(ctx, a.bot, message.Chat.ID, a.stats.String(), textProgress)
+}
+
+func (a *app) handleCancel(ctx context.Context, message *tgbotapi.Message) {
+	lang := languageOf(message)
+	if a.active.cancel(message.Chat.ID) == 0 {
+		sendText(ctx, a.bot, message.Chat.ID, localize(lang, msgNothingToCancel), textProgress)
+		return
+	}
+	sendText(ctx, a.bot, message.Chat.ID, localize(lang, msgCancelled), textProgress)
+}
+
+func (a *app) handleFit(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+	settings := a.settings.Get(chatID)
+
+	switch strings.ToLower(message.CommandArguments()) {
+	case "":
+		settings.Fit = !settings.Fit
+	case "on":
+		settings.Fit = true
+	case "off":
+		settings.Fit = false
+	default:
+		sendText(ctx, a.bot, chatID, localize(lang, msgFitUsage), textError)
+		return
+	}
+	a.settings.Set(chatID, settings)
+
+	if settings.Fit {
+		sendText(ctx, a.bot, chatID, localize(lang, msgFitOn), textProgress)
+	} else {
+		sendText(ctx, a.bot, chatID, localize(lang, msgFitOff), textProgress)
+	}
+}
+
+// handleQuiet turns quiet mode on or off for the chat, or toggles it when
+// given no argument. Quiet chats get results and errors but no progress
+// messages.
+func (a *app) handleQuiet(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	var quiet bool
+	switch strings.ToLower(message.CommandArguments()) {
+	case "":
+		quiet = !a.isQuiet(chatID)
+	case "on":
+		quiet = true
+	case "off":
+		quiet = false
+	default:
+		sendText(ctx, a.bot, chatID, localize(lang, msgQuietUsage), textError)
+		return
+	}
+
+	settings := a.settings.Get(chatID)
+	settings.Quiet = &quiet
+	a.settings.Set(chatID, settings)
+
+	if quiet {
+		sendText(ctx, a.bot, chatID, localize(lang, msgQuietOn), textProgress)
+	} else {
+		sendText(ctx, a.bot, chatID, localize(lang, msgQuietOff), textProgress)
+	}
+}
+
+// isQuiet reports whether progress messages are suppressed for chatID.
+func (a *app) isQuiet(chatID int64) bool {
+	if quiet := a.settings.Get(chatID).Quiet; quiet != nil {
+		return *quiet
+	}
+	return a.cfg.QuietMode
+}
+
+// sendStatus sends an intermediate progress message unless chatID is in
+// quiet mode, in which case it returns a zero Message that progress
+// reporters treat as "nothing to edit". Results and errors don't go
+// through here.
+func (a *app) sendStatus(ctx context.Context, chatID int64, text string) tgbotapi.Message {
+	if a.isQuiet(chatID) {
+		return tgbotapi.Message{}
+	}
+	return sendText(ctx, a.bot, chatID, text, textProgress)
+}
+
+// handleFeedback passes the user's text on to the admin chat together with
+// who sent it.
+func (a *app) handleFeedback(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" {
+		sendText(ctx, a.bot, chatID, localize(lang, msgFeedbackUsage), textError)
+		return
+	}
+	if a.cfg.AdminChatID == 0 {
+		sendText(ctx, a.bot, chatID, localize(lang, msgFeedbackUnavailable), textError)
+		return
+	}
+
+	from := "unknown user"
+	if u := message.From; u != nil {
+		from = fmt.Sprintf("user %d", u.ID)
+		if u.UserName != "" {
+			from += " (@" + u.UserName + ")"
+		}
+	}
+	report := fmt.Sprintf("Feedback from %s in chat %d:\n\n%s", from, chatID, text)
+	msg := tgbotapi.NewMessage(a.cfg.AdminChatID, truncateText(report, maxMessageLength))
+	if _, err := a.bot.Send(msg); err != nil {
+		slog.Error("Error forwarding feedback", "chat_id", chatID, "err", err)
+		sendText(ctx, a.bot, chatID, localize(lang, msgFeedbackUnavailable), textError)
+		return
+	}
+	sendText(ctx, a.bot, chatID, localize(lang, msgFeedbackSent), textProgress)
+}
+
+func (a *app) handleQuality(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+	settings := a.settings.Get(chatID)
+
+	level := strings.ToLower(message.CommandArguments())
+	if level == "" {
+		sendText(ctx, a.bot, chatID, localize(lang, msgCurrentQuality, qualityOf(settings)), textProgress)
+		return
+	}
+	if _, ok := qualityLevels[level]; !ok {
+		sendText(ctx, a.bot, chatID, localize(lang, msgQualityUsage), textError)
+		return
+	}
+
+	settings.Quality = level
+	a.settings.Set(chatID, settings)
+	sendText(ctx, a.bot, chatID, localize(lang, msgQualitySet, level), textProgress)
+}
+
+func (a *app) handleSetSize(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+	arg := message.CommandArguments()
+
+	if arg == "" {
+		sendText(ctx, a.bot, chatID, localize(lang, msgCurrentSize, a.videoSize(chatID), minVideoSize, maxVideoSize), textProgress)
+		return
+	}
+
+	size, err := strconv.Atoi(arg)
+	if err != nil || !validVideoSize(size) {
+		sendText(ctx, a.bot, chatID, localize(lang, msgInvalidSize, minVideoSize, maxVideoSize, videoSizeStep), textError)
+		return
 	}
+
+	settings := a.settings.Get(chatID)
+	settings.VideoSize = size
+	a.settings.Set(chatID, settings)
+
+	sendText(ctx, a.bot, chatID, localize(lang, msgSizeSet, size), textProgress)
+}
+
+// videoSize returns the chat's preferred diameter, falling back to the
+// configured default.
+func (a *app) videoSize(chatID int64) int {
+	if size := a.settings.Get(chatID).VideoSize; size != 0 {
+		return size
+	}
+	return a.cfg.VideoSize
+}
+
+func (a *app) handleVideo(ctx context.Context, message *tgbotapi.Message) {
+	a.processVideo(ctx, message, nil)
 }
 
-func handleVideo(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+// processVideo converts the video in message. Videos from an album share
+// batch as their status message; a nil batch gives the video its own.
+func (a *app) processVideo(ctx context.Context, message *tgbotapi.Message, batch *progressReporter) {
+	bot := a.bot
 	chatID := message.Chat.ID
+	lang := languageOf(message)
+	videoSize := a.videoSize(chatID)
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	logger := requestLogger(chatID)
+
+	var options captionOptions
+	caption := message.Caption
+	if isOptionString(caption) {
+		var err error
+		options, caption, err = parseCaptionOptions(caption)
+		if err != nil {
+			sendText(ctx, bot, chatID, captionOptionError(lang, err), textError)
+			return
+		}
+	}
+	if options.size != 0 {
+		videoSize = options.size
+	}
+	start := options.start
+
 	var fileID string
+	var fileUniqueID string
 	var fileName string
+	var mimeType string
+	var duration time.Duration
+	var isAnimation bool
 
-	if message.Video != nil {
+	// The precedence matches messageInput.
+	switch messageInput(message) {
+	case inputAnimation:
+		fileID = message.Animation.FileID
+		fileUniqueID = message.Animation.FileUniqueID
+		fileName = message.Animation.FileName
+		mimeType = message.Animation.MimeType
+		isAnimation = true
+	case inputVideo:
 		fileID = message.Video.FileID
+		fileUniqueID = message.Video.FileUniqueID
 		fileName = message.Video.FileName
-	} else if message.Document != nil {
+		mimeType = message.Video.MimeType
+		duration = time.Duration(message.Video.Duration) * time.Second
+		// Telegram reports the frame size, so 8K uploads can be turned
+		// away before downloading them.
+		if a.tooHighResolution(message.Video.Width, message.Video.Height) {
+			logger.Info("Rejecting high-resolution video", "width", message.Video.Width, "height", message.Video.Height)
+			sendText(ctx, bot, chatID, localize(lang, msgResolutionTooHigh, a.cfg.MaxResolution), textError)
+			return
+		}
+	case inputDocument:
+		mime := message.Document.MimeType
+		isAnimation = mime == "image/gif"
+		if mime != "" && !strings.HasPrefix(mime, "video/") && !isAnimation {
+			logger.Info("Rejecting non-video document", "mime_type", mime)
+			sendNotVideoMessage(ctx, bot, chatID, lang)
+			return
+		}
 		fileID = message.Document.FileID
+		fileUniqueID = message.Document.FileUniqueID
 		fileName = message.Document.FileName
-	} else {
-		sendErrorMessage(bot, chatID, "Please send a valid video file.")
+		mimeType = mime
+	default:
+		sendText(ctx, bot, chatID, localize(lang, msgInvalidVideo), textError)
+		return
+	}
+
+	logger = logger.With("file_id", fileID)
+	ctx = contextWithLogger(ctx, logger)
+
+	if len(options.applied) > 0 {
+		a.sendStatus(ctx, chatID, localize(lang, msgOptionsApplied, strings.Join(options.applied, ", ")))
+	}
+
+	// With the picker the size, and so the cache key, is only known once
+	// the user presses a button.
+	pickSize := batch == nil && message.From != nil && options.size == 0 && a.usesSizePicker(chatID)
+
+	c := conversion{
+		chatID:      chatID,
+		lang:        lang,
+		videoSize:   videoSize,
+		isAnimation: isAnimation,
+		duration:    duration,
+		caption:     caption,
+		start:       start,
+		crop:        options.crop,
+		quality:     options.quality,
+		fit:         options.fit,
+		batch:       batch,
+	}
+	a.applyTarget(ctx, &c, options.target, message)
+	if !pickSize {
+		c.cacheKey = a.cacheKey(fileUniqueID, c)
+		if a.sendCached(ctx, c) {
+			return
+		}
+	}
+
+	fileName = inputFileName(fileName, mimeType)
+
+	file, ok := a.getFile(ctx, fileID, chatID, lang)
+	if !ok {
+		return
+	}
+
+	inputPath, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, fileName, a.cfg.OutputFormat.Ext)
+
+	// Looping short animations needs a seekable input, and a start offset
+	// or crop position has to be checked against the probed input, so they
+	// always go through a temp file.
+	if a.cfg.StreamInput && !isAnimation && !pickSize && start == 0 && !c.crop.set {
+		if a.streamAndSend(ctx, c, file, outputPath) {
+			return
+		}
+		logger.Info("Piped conversion failed, retrying from a temp file")
+	}
+
+	if !a.download(ctx, file, inputPath, chatID, lang) {
 		return
 	}
 
-	// Ensure fileName is not empty and has a valid extension
-	if fileName == "" {
-		fileName = "video.mp4"
-	} else if filepath.Ext(fileName) == "" {
-		fileName += ".mp4"
+	// Documents may come without a name or with one that doesn't match the
+	// content, so check what was actually uploaded.
+	if messageInput(message) == inputDocument {
+		sniffed, err := sniffInput(ctx, inputPath)
+		if err != nil {
+			logger.Info("Rejecting unreadable document", "err", err)
+			os.Remove(inputPath)
+			sendNotVideoMessage(ctx, bot, chatID, lang)
+			return
+		}
+		inputPath = sniffed
 	}
 
-	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if pickSize {
+		a.offerSizes(ctx, pendingInput{
+			conversion:   c,
+			message:      message,
+			userID:       message.From.ID,
+			fileUniqueID: fileUniqueID,
+			inputPath:    inputPath,
+			outputPath:   outputPath,
+		})
+		return
+	}
+	if batch != nil {
+		defer os.Remove(inputPath)
+	} else {
+		// Keep the input for /convertlast.
+		defer a.lastInputs.put(chatID, lastInput{path: inputPath, fileUniqueID: fileUniqueID, isAnimation: isAnimation, duration: duration})
+	}
+
+	a.convertAndSend(ctx, c, inputPath, outputPath)
+}
+
+// getFile resolves fileID to a downloadable file, telling the user why if
+// it can't be fetched.
+func (a *app) getFile(ctx context.Context, fileID string, chatID int64, lang string) (tgbotapi.File, bool) {
+	logger := loggerFromContext(ctx)
+
+	file, err := a.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if errors.Is(err, errFileTooBig) {
+		logger.Info("File exceeds Telegram download limit", "err", err)
+		sendText(ctx, a.bot, chatID, localize(lang, msgTelegramTooBig), textError)
+		return file, false
+	}
 	if err != nil {
-		log.Println("Error getting file:", err)
-		sendErrorMessage(bot, chatID, "Failed to process the video. Please try again.")
+		logger.Error("Error getting file", "err", err)
+		a.recordFailure()
+		sendText(ctx, a.bot, chatID, localize(lang, msgProcessFailed), textError)
+		return file, false
+	}
+
+	if int64(file.FileSize) > a.cfg.MaxFileSize {
+		logger.Info("Rejecting oversized file", "size", file.FileSize)
+		sendTooLargeMessage(ctx, a.bot, chatID, lang, a.cfg.MaxFileSize)
+		return file, false
+	}
+	return file, true
+}
+
+// download saves file to inputPath, telling the user why if it fails. On
+// success the caller owns inputPath.
+func (a *app) download(ctx context.Context, file tgbotapi.File, inputPath string, chatID int64, lang string) bool {
+	logger := loggerFromContext(ctx)
+
+	if !a.hasRoomForJob(ctx, chatID, lang) {
+		return false
+	}
+
+	logger.Info("Downloading video", "path", inputPath)
+	downloaded, err := downloadVerified(ctx, a.bot, file, inputPath, a.cfg.MaxFileSize)
+	if ctx.Err() != nil {
+		logger.Info("Download cancelled")
+		return false
+	}
+	if outOfStorage(err) {
+		a.reportOutOfStorage(ctx, chatID, lang, err)
+		return false
+	}
+	if errors.Is(err, errFileTooLarge) {
+		logger.Info("Download exceeded size limit", "limit", a.cfg.MaxFileSize)
+		sendTooLargeMessage(ctx, a.bot, chatID, lang, a.cfg.MaxFileSize)
+		return false
+	}
+	if errors.Is(err, errIncompleteDownload) {
+		logger.Error("Download incomplete after retry", "err", err)
+		a.recordFailure()
+		sendText(ctx, a.bot, chatID, localize(lang, msgDownloadIncomplete), textError)
+		return false
+	}
+	if err != nil {
+		logger.Error("Error downloading file", "err", err)
+		a.recordFailure()
+		sendText(ctx, a.bot, chatID, localize(lang, msgDownloadFailed), textError)
+		return false
+	}
+	a.recordDownload(downloaded)
+	return true
+}
+
+// cacheKey identifies the note produced from a source file by c, covering
+// every setting that affects the output.
+func (a *app) cacheKey(fileUniqueID string, c conversion) string {
+	settings := a.settingsFor(c)
+	return fmt.Sprintf("%s|%d|fit=%t|quality=%s|from=%s|crop=%s|watermark=%s",
+		fileUniqueID, c.videoSize, settings.Fit, qualityOf(settings), c.start, c.crop, settings.Watermark)
+}
+
+// sendCached re-sends a previously processed note for c.cacheKey, reporting
+// whether it did. Entries Telegram rejects are dropped.
+func (a *app) sendCached(ctx context.Context, c conversion) bool {
+	cachedPath, ok := a.cache.get(c.cacheKey)
+	if !ok {
+		return false
+	}
+
+	logger := loggerFromContext(ctx)
+	videoNote := tgbotapi.NewVideoNote(c.destination(), c.videoSize, tgbotapi.FilePath(cachedPath))
+	if _, err := a.bot.Send(videoNote); err != nil {
+		logger.Warn("Error sending cached video note, reprocessing", "err", err)
+		a.cache.invalidate(c.cacheKey)
+		return false
+	}
+
+	logger.Info("Sent cached video note")
+	sendCaption(ctx, a.bot, c.destination(), c.caption)
+	a.confirmTargetPost(ctx, c)
+	return true
+}
+
+// conversion describes one input being turned into a video note.
+type conversion struct {
+	chatID      int64
+	lang        string
+	videoSize   int
+	isAnimation bool
+	// duration is the length reported by Telegram, or zero if unknown.
+	duration time.Duration
+	// caption is forwarded after the note, if set.
+	caption string
+	// start is where in the input the note begins, from a from= caption.
+	start time.Duration
+	// crop is where the square is cut from, from a crop= caption.
+	crop cropPosition
+	// quality and fit, if set, override the chat's settings for this
+	// conversion only; see captionOptions.
+	quality string
+	fit     *bool
+	// target, if set, is the chat the note and caption are posted to;
+	// status messages still go to chatID.
+	target int64
+	// eta, if known, is shown with the processing message.
+	eta time.Duration
+	// cacheKey stores the result in the output cache; empty disables it.
+	cacheKey string
+	// batch, if set, is the album status message to report progress in
+	// instead of sending per-video status messages.
+	batch *progressReporter
+}
+
+// convertAndSend validates the downloaded input, runs ffmpeg and delivers
+// the note. It owns outputPath but not inputPath.
+func (a *app) convertAndSend(ctx context.Context, c conversion, inputPath, outputPath string) {
+	bot := a.bot
+	chatID := c.chatID
+	lang := c.lang
+	logger := loggerFromContext(ctx)
+
+	hasVideo, err := probeHasVideo(ctx, inputPath)
+	if err != nil {
+		logger.Warn("Error probing video streams", "err", err)
+	}
+	if !hasVideo {
+		sendNotVideoMessage(ctx, bot, chatID, lang)
 		return
 	}
 
-	inputPath := filepath.Join(os.TempDir(), fmt.Sprintf("input_%d_%s", chatID, fileName))
-	log.Println("Downloading video to", inputPath)
-	err = downloadFile(bot, file.FilePath, inputPath)
+	width, height, err := probeResolution(ctx, inputPath)
 	if err != nil {
-		log.Println("Error downloading file:", err)
-		sendErrorMessage(bot, chatID, "Failed to download the video. Please try again.")
+		logger.Warn("Error probing video resolution", "err", err)
+	} else if a.tooHighResolution(width, height) {
+		logger.Info("Rejecting high-resolution video", "width", width, "height", height)
+		sendText(ctx, bot, chatID, localize(lang, msgResolutionTooHigh, a.cfg.MaxResolution), textError)
 		return
 	}
-	defer os.Remove(inputPath)
 
-	sendProgressMessage(bot, chatID, "Video downloaded. Processing...")
+	opts := a.videoOptions(c)
+	opts.Square = width > 0 && width == height
+	if c.isAnimation {
+		opts.Audio = audioNone
+	} else {
+		opts.Audio = probeAudioMode(ctx, inputPath, opts.Format)
+	}
+	if opts.Rotation, err = probeRotation(ctx, inputPath); err != nil {
+		logger.Warn("Error probing video rotation", "err", err)
+	}
+
+	if c.crop.set && width > 0 {
+		// The crop sees the frame after rotation.
+		frameWidth, frameHeight := width, height
+		if opts.Rotation == 90 || opts.Rotation == 270 {
+			frameWidth, frameHeight = height, width
+		}
+		if !c.crop.fits(frameWidth, frameHeight) {
+			side := min(frameWidth, frameHeight)
+			sendText(ctx, bot, chatID, localize(lang, msgCropOutOfFrame, frameWidth-side, frameHeight-side), textError)
+			return
+		}
+	}
+	opts.Crop = c.crop
 
-	outputPath := filepath.Join(os.TempDir(), "output_"+fileName)
-	err = makeCircularVideo(ctx, inputPath, outputPath)
+	opts.Start = c.start
+	duration, err := probeDuration(ctx, inputPath)
 	if err != nil {
-		log.Println("Error processing video:", err)
-		sendErrorMessage(bot, chatID, "Failed to process the video. Please try again.")
+		logger.Warn("Error probing video duration", "err", err)
+	} else if c.start >= duration {
+		sendText(ctx, bot, chatID, localize(lang, msgOffsetTooLate, int(duration.Seconds())), textError)
 		return
+	} else if c.start > 0 {
+		// Only what follows the offset counts towards the limit, and
+		// bounding -t by it keeps the progress percentage right.
+		if duration-c.start > opts.MaxDuration {
+			a.sendStatus(ctx, chatID, localize(lang, msgTrimmed, int(opts.MaxDuration.Seconds())))
+		}
+		opts.MaxDuration = min(opts.MaxDuration, duration-c.start)
+	} else if duration > opts.MaxDuration {
+		a.sendStatus(ctx, chatID, localize(lang, msgTrimmed, int(opts.MaxDuration.Seconds())))
+	} else if c.isAnimation && duration < minAnimationDuration && minAnimationDuration <= opts.MaxDuration {
+		opts.Loop = true
+		opts.MaxDuration = minAnimationDuration
+	}
+
+	outputLength := opts.MaxDuration
+	if err == nil && !opts.Loop {
+		outputLength = min(outputLength, duration-c.start)
+	}
+	if err == nil {
+		opts.Length = outputLength
+	}
+	// Short inputs are looped up to MinDuration, so that much is encoded.
+	if opts.needsPadding() {
+		outputLength = opts.MinDuration
+	}
+	units := workUnits(outputLength, width, height)
+	c.eta = a.estimateProcessing(units)
+
+	if fps, err := probeFrameRate(ctx, inputPath); err != nil {
+		logger.Warn("Error probing frame rate", "err", err)
+	} else if a.cfg.MaxFPS > 0 && fps > float64(a.cfg.MaxFPS) {
+		logger.Debug("Capping frame rate", "source_fps", fps, "max_fps", a.cfg.MaxFPS)
+		opts.FrameRate = a.cfg.MaxFPS
 	}
-	defer os.Remove(outputPath)
 
-	sendProgressMessage(bot, chatID, "Video processed. Sending...")
+	if opts.Square {
+		codec, pixFmt, err := probeVideoFormat(ctx, inputPath)
+		if err != nil {
+			logger.Warn("Error probing video format", "err", err)
+		}
+		opts.CopyVideo = canCopyVideo(codec, pixFmt, width, opts)
+	}
 
-	videoNote := tgbotapi.NewVideoNote(chatID, defaultVideoSize, tgbotapi.FilePath(outputPath))
-	_, err = bot.Send(videoNote)
+	cached := false
+	defer func() {
+		if !cached {
+			os.Remove(outputPath)
+		}
+	}()
+
+	processing, err := a.encodeToFit(ctx, c, inputPath, outputPath, opts)
+	if ctx.Err() != nil {
+		logger.Info("Processing cancelled")
+		return
+	}
+	if errors.Is(err, errFFmpegTimeout) {
+		a.reportTimeout(ctx, c, err)
+		return
+	}
+	if errors.Is(err, errOutputTooLarge) {
+		a.reportOutputTooLarge(ctx, c, err)
+		return
+	}
 	if err != nil {
-		log.Println("Error sending video note:", err)
+		logger.Error("Error processing video", "err", err)
+		a.reportFFmpegFailure(ctx, c, err)
+		return
+	}
+	a.stats.recordWork(units, processing)
+	logger.Debug("Processing estimate", "estimated", c.eta, "actual", processing)
+
+	cached = a.deliver(ctx, c, outputPath, processing)
+}
+
+// tooHighResolution reports whether a width x height frame exceeds
+// MaxResolution on its longer side.
+func (a *app) tooHighResolution(width, height int) bool {
+	return max(width, height) > a.cfg.MaxResolution
+}
+
+// encodeToFit encodes inputPath and, while the result is above
+// MaxOutputSize, re-encodes it with progressively higher CRF. A failed
+// run is retried once with lenient decoding. It returns the total encoding
+// time.
+func (a *app) encodeToFit(ctx context.Context, c conversion, inputPath, outputPath string, opts videoOptions) (time.Duration, error) {
+	logger := loggerFromContext(ctx)
+
+	var total time.Duration
+	for pass := 1; ; pass++ {
+		processing, err := a.encode(ctx, c, videoSource{Path: inputPath}, outputPath, opts)
+		total += processing
+		retryable := err != nil && ctx.Err() == nil && !errors.Is(err, errFFmpegTimeout) && !outOfStorage(err) && !killedBySignal(err)
+		if retryable && opts.CopyVideo {
+			logger.Info("Copying the video stream failed, re-encoding", "err", err)
+			opts.CopyVideo = false
+			continue
+		}
+		if retryable && !opts.Lenient {
+			logger.Warn("ffmpeg failed, retrying with lenient decoding", "err", err)
+			opts.Lenient = true
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
 
-		if err.Error() == voiceMsgRestrictionErr {
-			log.Println("Permission to send video notes is forbidden.")
-			sendErrorMessage(bot, chatID, "It seems that I don't have permission to send video notes. "+
-				"Please check if you allow sending voice messages in the settings.")
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			return total, err
+		}
+		if info.Size() <= a.cfg.MaxOutputSize {
+			logger.Info("Encoded video", "size", info.Size(), "crf", opts.Encoder.CRF, "preset", opts.Encoder.Preset, "passes", pass)
+			return total, nil
+		}
+		if opts.CopyVideo {
+			// The copied stream can't be compressed; encode it instead.
+			opts.CopyVideo = false
+			logger.Info("Copied video too large, re-encoding", "size", info.Size(), "limit", a.cfg.MaxOutputSize)
+			continue
+		}
+		if pass > compressionPasses || opts.Encoder.CRF >= maxCRF {
+			os.Remove(outputPath)
+			return total, fmt.Errorf("%w: %d bytes at CRF %d", errOutputTooLarge, info.Size(), opts.Encoder.CRF)
+		}
+
+		opts.Encoder.CRF = min(opts.Encoder.CRF+compressionCRFStep, maxCRF)
+		logger.Info("Output too large, re-encoding", "size", info.Size(), "limit", a.cfg.MaxOutputSize, "crf", opts.Encoder.CRF)
+	}
+}
+
+// reportOutputTooLarge tells the user the note couldn't be compressed
+// enough to send.
+func (a *app) reportOutputTooLarge(ctx context.Context, c conversion, err error) {
+	loggerFromContext(ctx).Warn("Output too large to send", "err", err)
+	a.recordFailure()
+	sendText(ctx, a.bot, c.chatID, localize(c.lang, msgOutputTooLarge), textError)
+}
+
+// reportTimeout tells the user their video took too long to convert.
+func (a *app) reportTimeout(ctx context.Context, c conversion, err error) {
+	loggerFromContext(ctx).Warn("Processing timed out", "err", err)
+	a.metrics.ffmpegFailures.Inc()
+	a.recordFailure()
+	sendText(ctx, a.bot, c.chatID, localize(c.lang, msgProcessTimeout), textError)
+}
+
+// settingsFor returns the chat's settings with c's per-video overrides
+// applied.
+func (a *app) settingsFor(c conversion) userSettings {
+	settings := a.settings.Get(c.chatID)
+	if c.quality != "" {
+		settings.Quality = c.quality
+	}
+	if c.fit != nil {
+		settings.Fit = *c.fit
+	}
+	return settings
+}
+
+// videoOptions returns the encoding options for c before any probing.
+func (a *app) videoOptions(c conversion) videoOptions {
+	settings := a.settingsFor(c)
+	encoder := qualityLevels[qualityOf(settings)]
+	if a.cfg.FFmpegPreset != "" {
+		encoder.Preset = a.cfg.FFmpegPreset
+	}
+	opts := videoOptions{
+		Size:        c.videoSize,
+		MaxDuration: a.cfg.MaxDuration,
+		MinDuration: a.cfg.MinDuration,
+		Fit:         settings.Fit,
+		Encoder:     encoder,
+		Format:      a.cfg.OutputFormat,
+	}
+	if settings.Watermark != "" {
+		// A watermark lost with the data directory is skipped rather than
+		// failing every conversion.
+		path := a.watermarkPath(settings.Watermark)
+		if _, err := os.Stat(path); err == nil {
+			opts.Watermark = path
 		} else {
-			sendErrorMessage(bot, chatID, "Failed to send the processed video. Please try again.")
+			slog.Warn("Watermark image unavailable, ignoring it", "chat_id", c.chatID, "err", err)
+		}
+	}
+	return opts
+}
+
+// encode runs ffmpeg once a job slot is free, reporting progress in a status
+// message, and returns how long the conversion took.
+func (a *app) encode(ctx context.Context, c conversion, src videoSource, outputPath string, opts videoOptions) (time.Duration, error) {
+	if !a.acquireJobSlot(ctx, c.chatID, c.lang, c.batch == nil) {
+		return 0, ctx.Err()
+	}
+	defer a.releaseJobSlot()
+
+	progress := c.batch
+	if progress == nil {
+		text := localize(c.lang, msgProcessing)
+		if c.eta > 0 {
+			text += " " + localize(c.lang, msgProcessingETA, int(c.eta.Seconds()))
 		}
+		status := a.sendStatus(ctx, c.chatID, text)
+		progress = newProgressReporter(loggerFromContext(ctx), a.bot, c.chatID, status, text)
+	}
+
+	// The deadline only covers ffmpeg itself, not the time spent queued.
+	ffmpegCtx, cancel := context.WithTimeout(ctx, a.cfg.FFmpegTimeout)
+	defer cancel()
+
+	action := tgbotapi.ChatRecordVideoNote
+	if opts.Letterbox {
+		action = tgbotapi.ChatRecordVideo
 	}
+	stopAction := a.showChatAction(ctx, c.chatID, action)
+	defer stopAction()
+
+	started := time.Now()
+	err := a.transcode(ffmpegCtx, src, outputPath, opts, progress.Report)
+	if err != nil && ctx.Err() == nil && errors.Is(ffmpegCtx.Err(), context.DeadlineExceeded) {
+		os.Remove(outputPath)
+		err = fmt.Errorf("%w after %s", errFFmpegTimeout, a.cfg.FFmpegTimeout)
+	}
+	return time.Since(started), err
 }
 
-func downloadFile(bot *tgbotapi.BotAPI, filePath, destPath string) error {
-	url := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", bot.Token, filePath)
+// deliver sends the finished note, falling back to a regular video where
+// notes are forbidden, and records the outcome. It reports whether
+// outputPath now belongs to the cache.
+func (a *app) deliver(ctx context.Context, c conversion, outputPath string, processing time.Duration) bool {
+	bot := a.bot
+	chatID := c.chatID
+	lang := c.lang
+	logger := loggerFromContext(ctx)
+
+	if c.batch == nil {
+		a.sendStatus(ctx, chatID, localize(lang, msgSending))
+	}
 
-	resp, err := http.Get(url)
+	dest := c.destination()
+	videoNote := tgbotapi.NewVideoNote(dest, c.videoSize, tgbotapi.FilePath(outputPath))
+	stopAction := a.showChatAction(ctx, dest, tgbotapi.ChatUploadVideoNote)
+	_, err := bot.Send(videoNote)
+	stopAction()
+	if err != nil && dest != chatID {
+		logger.Warn("Error posting note to target chat, replying instead", "target_chat_id", dest, "err", err)
+		sendText(ctx, bot, chatID, localize(lang, msgTargetSendFailed), textError)
+		c.target, dest = 0, chatID
+		_, err = bot.Send(tgbotapi.NewVideoNote(chatID, c.videoSize, tgbotapi.FilePath(outputPath)))
+	}
+	if err != nil {
+		logger.Error("Error sending video note", "err", err)
+
+		key := msgSendFailed
+		switch {
+		case errors.Is(err, errVoiceMessagesForbidden):
+			logger.Warn("Permission to send video notes is forbidden")
+			if a.cfg.VideoFallback && sendVideoFallback(logger, bot, chatID, lang, outputPath) {
+				a.recordSuccess(processing)
+				return false
+			}
+			key = msgVoiceForbidden
+		case errors.Is(err, errRequestTooLarge):
+			key = msgNoteTooLarge
+		}
+		sendText(ctx, bot, chatID, localize(lang, key), textError)
+		a.recordFailure()
+		return false
+	}
+
+	a.recordSuccess(processing)
+	a.archive(ctx, chatID, outputPath)
+	cached := c.cacheKey != "" && a.cache.put(c.cacheKey, outputPath)
+
+	sendCaption(ctx, bot, dest, c.caption)
+	a.confirmTargetPost(ctx, c)
+	return cached
+}
+
+// sendCaption passes the source caption on as a follow-up message, since
+// video notes can't carry captions.
+func sendCaption(ctx context.Context, bot *telegramClient, chatID int64, caption string) {
+	if caption != "" {
+		sendText(ctx, bot, chatID, truncateText(caption, maxMessageLength), textProgress)
+	}
+}
+
+// truncateText shortens s to at most limit runes, marking the cut with an
+// ellipsis.
+func truncateText(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// sendVideoFallback sends the processed square video as a regular video for
+// users whose privacy settings forbid video notes.
+func sendVideoFallback(logger *slog.Logger, bot *telegramClient, chatID int64, lang, outputPath string) bool {
+	video := tgbotapi.NewVideo(chatID, tgbotapi.FilePath(outputPath))
+	video.Caption = localize(lang, msgVideoFallback)
+	if _, err := bot.Send(video); err != nil {
+		logger.Error("Error sending fallback video", "err", err)
+		return false
+	}
+	return true
+}
+
+// fileEndpoint is the format of file download URLs, taking the bot token
+// and the file path. It is only changed to point at a stand-in server.
+var fileEndpoint = tgbotapi.FileEndpoint
+
+// fileDownloadURL is where Telegram serves filePath for the bot with
+// token. The URL embeds the token, so it must never be logged; use
+// redactedDownloadURL instead.
+func fileDownloadURL(token, filePath string) string {
+	return fmt.Sprintf(fileEndpoint, token, filePath)
+}
+
+// redactedDownloadURL is fileDownloadURL with the token blanked out.
+func redactedDownloadURL(filePath string) string {
+	return fileDownloadURL("[redacted]", filePath)
+}
+
+// redactURLError strips the request URL, which contains the bot token, from
+// a *url.Error so it is safe to log.
+func redactURLError(filePath string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("download %s: %w", redactedDownloadURL(filePath), urlErr.Err)
+	}
+	return err
+}
+
+// probeAudioMode picks the fastest audio handling that still yields a
+// track format accepts, falling back to re-encoding if probing fails.
+func probeAudioMode(ctx context.Context, path string, format outputFormat) audioMode {
+	codec, err := probeAudioCodec(ctx, path)
+	if err != nil {
+		loggerFromContext(ctx).Warn("Error probing audio codec", "err", err)
+		return audioEncode
+	}
+
+	switch {
+	case codec == "":
+		return audioNone
+	case format.CopyableAudio[codec]:
+		return audioCopy
+	default:
+		loggerFromContext(ctx).Debug("Re-encoding incompatible audio", "codec", codec)
+		return audioEncode
+	}
+}
+
+// acquireJobSlot blocks until an ffmpeg slot is free, telling the user they
+// are queued if it can't start right away and notify is set. It returns
+// false if ctx is done first.
+func (a *app) acquireJobSlot(ctx context.Context, chatID int64, lang string, notify bool) bool {
+	select {
+	case a.jobs <- struct{}{}:
+		return true
+	default:
+	}
+
+	if notify {
+		a.sendStatus(ctx, chatID, localize(lang, msgQueued))
+	}
+
+	select {
+	case a.jobs <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (a *app) releaseJobSlot() {
+	<-a.jobs
+}
+
+// downloadAttempts is how many times a download that broke off is resumed
+// before giving up.
+const downloadAttempts = 3
+
+// downloadFile writes at most maxSize bytes of file to destPath and returns
+// the number of bytes transferred, or errFileTooLarge if the body is longer.
+// If destPath already holds the start of the file from an earlier attempt,
+// only the rest is requested with a Range header; if the server ignores it,
+// the file is written again from the start. A body that breaks off is
+// reported as errIncompleteDownload and what arrived is kept for resuming.
+func downloadFile(ctx context.Context, bot *telegramClient, file tgbotapi.File, destPath string, maxSize int64) (int64, error) {
+	var offset int64
+	if info, err := os.Stat(destPath); err == nil {
+		offset = info.Size()
+	}
+	if file.FileSize > 0 && offset >= int64(file.FileSize) {
+		return 0, nil
+	}
+
+	body, resumed, err := openDownload(ctx, bot, file, offset)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	if !resumed {
+		offset = 0
+	}
+	return writeDownload(body, destPath, offset, maxSize)
+}
+
+// openDownload starts fetching file from Telegram at offset and returns the
+// response body for the caller to consume and close. resumed reports
+// whether the server honoured the offset; if not, the body is the whole
+// file.
+func openDownload(ctx context.Context, bot *telegramClient, file tgbotapi.File, offset int64) (body io.ReadCloser, resumed bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileDownloadURL(bot.Token, file.FilePath), nil)
+	if err != nil {
+		return nil, false, redactURLError(file.FilePath, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, false, redactURLError(file.FilePath, err)
+	}
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		return resp.Body, true, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, false, nil
+	default:
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("download %s: unexpected status %s", redactedDownloadURL(file.FilePath), resp.Status)
+	}
+}
+
+// writeDownload writes r to destPath starting at offset, replacing the
+// file when offset is zero, and keeps the whole file under maxSize. It
+// returns the number of bytes written by this call.
+func writeDownload(r io.Reader, destPath string, offset, maxSize int64) (n int64, err error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(destPath, flags, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	n, err = io.Copy(out, io.LimitReader(r, maxSize-offset+1))
+	if err != nil {
+		return n, fmt.Errorf("%w: %w", errIncompleteDownload, err)
+	}
+	if offset+n > maxSize {
+		os.Remove(destPath)
+		return n, errFileTooLarge
+	}
+	return n, nil
+}
+
+// downloadVerified downloads file like downloadFile and checks the result
+// against the size Telegram reported, resuming up to downloadAttempts times
+// when it comes up short. destPath is removed if the download fails.
+func downloadVerified(ctx context.Context, bot *telegramClient, file tgbotapi.File, destPath string, maxSize int64) (int64, error) {
+	var total int64
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		var n int64
+		n, err = downloadFile(ctx, bot, file, destPath, maxSize)
+		total += n
+		if err == nil {
+			err = verifyDownload(destPath, int64(file.FileSize))
+		}
+		if err == nil {
+			return total, nil
+		}
+		if !errors.Is(err, errIncompleteDownload) || outOfStorage(err) || ctx.Err() != nil {
+			break
+		}
+		loggerFromContext(ctx).Warn("Resuming incomplete download", "attempt", attempt, "err", err)
+	}
+	os.Remove(destPath)
+	return total, err
+}
+
+// verifyDownload checks that path is non-empty and, when expected is known,
+// at least that long.
+func verifyDownload(path string, expected int64) error {
+	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	if info.Size() == 0 {
+		return fmt.Errorf("%w: empty file", errIncompleteDownload)
+	}
+	if expected > 0 && info.Size() < expected {
+		return fmt.Errorf("%w: got %d of %d bytes", errIncompleteDownload, info.Size(), expected)
+	}
+	return nil
+}
 
+// writeLimited copies at most maxSize bytes of r to destPath, returning
+// errFileTooLarge if r is longer. destPath is removed on failure.
+func writeLimited(r io.Reader, destPath string, maxSize int64) (n int64, err error) {
 	out, err := os.Create(destPath)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer out.Close()
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(destPath)
+		}
+	}()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	n, err = io.Copy(out, io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return n, err
+	}
+	if n > maxSize {
+		return n, errFileTooLarge
+	}
+	return n, nil
+}
+
+// videoSource is ffmpeg's input: a file on disk, or, when Reader is set, a
+// stream piped to its stdin.
+type videoSource struct {
+	Path   string
+	Reader io.Reader
+}
+
+func (s videoSource) arg() string {
+	if s.Reader != nil {
+		return "pipe:0"
+	}
+	return s.Path
 }
 
-func makeCircularVideo(ctx context.Context, inputPath, outputPath string) error {
-	cmd := exec.CommandContext(ctx,
-		"ffmpeg",
-		"-i", inputPath,
-		"-vf", fmt.Sprintf("crop=min(iw\\,ih):min(iw\\,ih),scale=%d:%d,format=yuv420p", defaultVideoSize, defaultVideoSize),
-		"-c:a", "copy",
-		"-y",
-		outputPath,
-	)
+// transcoder encodes src into outputPath according to opts, reporting
+// progress as a percentage.
+type transcoder func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(percent int)) error
 
+func makeCircularVideo(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(percent int)) error {
+	// A piped download can't be read twice, so streamed inputs stay short.
+	length := opts.MaxDuration
+	pad := opts.needsPadding() && src.Reader == nil
+	if pad {
+		length = opts.MinDuration
+		loggerFromContext(ctx).Debug("Looping short input", "length", opts.Length, "min_duration", opts.MinDuration)
+	}
+
+	var args []string
+	if opts.Loop || pad {
+		args = append(args, "-stream_loop", "-1")
+	}
+	if opts.Rotation != 0 {
+		args = append(args, "-noautorotate")
+	}
+	if opts.Lenient {
+		args = append(args, "-err_detect", "ignore_err", "-fflags", "+genpts")
+	}
+	if opts.Start > 0 {
+		// Before -i, ffmpeg seeks the input instead of decoding up to it.
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', -1, 64))
+	}
+	args = append(args, "-i", src.arg())
+	// Only notes made from a video carry the watermark.
+	watermark := opts.Watermark != "" && !opts.Waveform && !opts.Letterbox && !opts.CopyVideo
+	if watermark {
+		args = append(args, "-i", opts.Watermark)
+	}
+	args = append(args, "-t", strconv.FormatFloat(length.Seconds(), 'f', -1, 64))
+	switch {
+	case opts.CopyVideo:
+		args = append(args, "-c:v", "copy")
+	case opts.Waveform:
+		args = append(args, "-filter_complex", buildWaveformFilter(opts.Size), "-map", "[v]", "-map", "0:a")
+		args = append(args, opts.Format.videoArgs(opts.Encoder)...)
+	case watermark:
+		args = append(args, "-filter_complex", buildWatermarkFilter(videoFilter(opts), opts.Size), "-map", "[v]", "-map", "0:a?")
+		args = append(args, opts.Format.videoArgs(opts.Encoder)...)
+	default:
+		args = append(args, "-vf", videoFilter(opts))
+		args = append(args, opts.Format.videoArgs(opts.Encoder)...)
+	}
+	if opts.FrameRate > 0 && !opts.CopyVideo {
+		args = append(args, "-r", strconv.Itoa(opts.FrameRate))
+	}
+	if opts.Rotation != 0 {
+		// The frames are already upright; don't let players turn them again.
+		args = append(args, "-metadata:s:v:0", "rotate=0")
+	}
+	switch opts.Audio {
+	case audioNone:
+		args = append(args, "-an")
+	case audioCopy:
+		args = append(args, "-c:a", "copy")
+	default:
+		// Codecs the container can't take, e.g. Opus from WebM in mp4, may
+		// also carry surround layouts; downmix to stereo.
+		args = append(args, opts.Format.audioArgs()...)
+	}
+	// Progress comes as key=value lines on stdout, which is free since the
+	// output always goes to a file; -nostats drops the human-readable
+	// status line from stderr.
+	args = append(args, "-progress", "pipe:1", "-nostats", "-y", outputPath)
+
+	// CommandContext kills ffmpeg when ctx is done; WaitDelay makes sure
+	// Wait returns even if the stdin copy is stuck on a stalled download.
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stdin = src.Reader
+	cmd.WaitDelay = ffmpegWaitDelay
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return err
@@ -177,24 +1624,52 @@ func makeCircularVideo(ctx context.Context, inputPath, outputPath string) error
 		return err
 	}
 
-	go logFFmpegProgress(stderr)
+	// A looped input's own duration says nothing about the output length.
+	total := opts.Length
+	if opts.Loop || pad {
+		total = length
+	}
 
-	return cmd.Wait()
-}
+	// Both pipes must be read to EOF before Wait closes them.
+	stderrLog := &tailBuffer{limit: ffmpegLogLimit}
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		logFFmpegOutput(loggerFromContext(ctx), io.TeeReader(stderr, stderrLog))
+	}()
+	readFFmpegProgress(stdout, total, onProgress)
+	<-stderrDone
 
-func logFFmpegProgress(stderr io.ReadCloser) {
-	scanner := bufio.NewScanner(stderr)
-	for scanner.Scan() {
-		log.Println("FFmpeg:", scanner.Text())
+	if err := cmd.Wait(); err != nil {
+		return &ffmpegError{err: err, stderr: stderrLog.String()}
 	}
+	return nil
+}
+
+func sendNotVideoMessage(ctx context.Context, bot *telegramClient, chatID int64, lang string) {
+	sendText(ctx, bot, chatID, localize(lang, msgNotVideo), textError)
 }
 
-func sendErrorMessage(bot *tgbotapi.BotAPI, chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	bot.Send(msg)
+func sendTooLargeMessage(ctx context.Context, bot *telegramClient, chatID int64, lang string, maxSize int64) {
+	sendText(ctx, bot, chatID, localize(lang, msgTooLarge, maxSize>>20), textError)
 }
 
-func sendProgressMessage(bot *tgbotapi.BotAPI, chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	bot.Send(msg)
+// textKind says what a plain text message is for, so failures to send it
+// are logged with some context.
+type textKind string
+
+const (
+	textError    textKind = "error"
+	textProgress textKind = "progress"
+)
+
+// sendText sends text to chatID. A failure is only logged: there is no
+// better way to reach the user, and callers carry on regardless. The
+// returned message is zero if sending failed.
+func sendText(ctx context.Context, bot *telegramClient, chatID int64, text string, kind textKind) tgbotapi.Message {
+	sent, err := bot.Send(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		loggerFromContext(ctx).Warn("Error sending message", "chat_id", chatID, "kind", kind, "err", err)
+	}
+	return sent
 }
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testToken = "123456:test-token"
+
+// fakeTelegram mimics the Bot API endpoints the bot calls, plus file
+// downloads, and records what it was sent.
+type fakeTelegram struct {
+	*httptest.Server
+
+	// files maps file IDs to the content served for them; a file ID
+	// missing here downloads as 404.
+	files map[string][]byte
+	// rejectText, if set, makes sendMessage fail for texts it matches, as
+	// Telegram does once it rate limits a chat.
+	rejectText func(text string) bool
+
+	mu        sync.Mutex
+	calls     []string
+	texts     []string
+	notes     [][]byte
+	downloads int
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	tg := &fakeTelegram{files: make(map[string][]byte)}
+	tg.Server = httptest.NewServer(http.HandlerFunc(tg.serve))
+	t.Cleanup(tg.Close)
+
+	old := fileEndpoint
+	fileEndpoint = tg.URL + "/file/bot%s/%s"
+	t.Cleanup(func() { fileEndpoint = old })
+	return tg
+}
+
+func (tg *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+testToken+"/"); ok {
+		tg.serveFile(w, path)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tg.mu.Lock()
+	tg.calls = append(tg.calls, method)
+	tg.mu.Unlock()
+
+	chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	message := map[string]any{"message_id": 1, "date": 0, "chat": map[string]any{"id": chatID, "type": "private"}}
+
+	var result any
+	switch method {
+	case "getMe":
+		result = map[string]any{"id": 1, "is_bot": true, "first_name": "Circles", "username": "circles_bot"}
+	case "getFile":
+		fileID := r.FormValue("file_id")
+		result = map[string]any{"file_id": fileID, "file_unique_id": "u" + fileID, "file_path": "videos/" + fileID + ".mp4"}
+	case "sendMessage":
+		if tg.rejectText != nil && tg.rejectText(r.FormValue("text")) {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 400, "description": "Bad Request: rejected by test"})
+			return
+		}
+		tg.mu.Lock()
+		tg.texts = append(tg.texts, r.FormValue("text"))
+		tg.mu.Unlock()
+		result = message
+	case "sendVideoNote":
+		file, _, err := r.FormFile("video_note")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		tg.mu.Lock()
+		tg.notes = append(tg.notes, data)
+		tg.mu.Unlock()
+		result = message
+	case "editMessageText":
+		result = message
+	default:
+		result = true
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func (tg *fakeTelegram) serveFile(w http.ResponseWriter, path string) {
+	fileID := strings.TrimSuffix(strings.TrimPrefix(path, "videos/"), ".mp4")
+	data, ok := tg.files[fileID]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	tg.mu.Lock()
+	tg.downloads++
+	tg.mu.Unlock()
+	w.Write(data)
+}
+
+func (tg *fakeTelegram) called(method string) int {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	n := 0
+	for _, call := range tg.calls {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+func (tg *fakeTelegram) sentText(text string) bool {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	for _, sent := range tg.texts {
+		if sent == text {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestApp returns an app talking to tg, with default settings, work and
+// data directories of its own, no size picker and transcode running
+// stub.
+func newTestApp(t *testing.T, tg *fakeTelegram, stub transcoder) *app {
+	t.Helper()
+
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint(testToken, tg.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadConfig()
+	cfg.WorkDir = t.TempDir()
+	cfg.DataDir = t.TempDir()
+	cfg.SizePicker = false
+	cfg.StreamInput = false
+	cfg.Retry = retryPolicy{MaxAttempts: 1}
+
+	queue, err := loadJobQueue(filepath.Join(cfg.DataDir, "jobs.json"), cfg.MaxQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMetrics()
+	return &app{
+		bot:        &telegramClient{BotAPI: api, retry: cfg.Retry, metrics: m},
+		cfg:        cfg,
+		settings:   newSettingsStore(),
+		jobs:       make(chan struct{}, cfg.MaxConcurrentJobs),
+		active:     newJobRegistry(),
+		stats:      newStats(),
+		limiter:    newRateLimiter(cfg.RateLimit, cfg.RateLimitWindow),
+		cache:      newOutputCache(cfg.CacheSize, cfg.CacheTTL),
+		pending:    newPendingInputs(),
+		lastInputs: newLastInputs(),
+		queue:      queue,
+		metrics:    m,
+		dedup:      newUpdateDeduper(cfg.DedupWindow),
+		transcode:  stub,
+	}
+}
+
+// fakeVideoProbe stands in for ffprobe on a 640x360, 3.5 second H.264
+// video with AAC audio.
+func fakeVideoProbe(t *testing.T) {
+	fakeFFprobe(t, `case "$*" in
+*codec_type*) echo video ;;
+*width,height*) echo 640x360 ;;
+*format=duration*) echo 3.5 ;;
+*r_frame_rate*) echo 30/1 ;;
+*pix_fmt*) echo h264,yuv420p ;;
+*codec_name*) echo aac ;;
+esac`)
+}
+
+func videoMessage(chatID int64, fileID string, size int) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 10,
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		From:      &tgbotapi.User{ID: chatID, LanguageCode: "en"},
+		Video: &tgbotapi.Video{
+			FileID:       fileID,
+			FileUniqueID: "u" + fileID,
+			Width:        640,
+			Height:       360,
+			Duration:     3,
+			FileSize:     size,
+		},
+	}
+}
+
+func TestHandleVideoSendsNote(t *testing.T) {
+	tg := newFakeTelegram(t)
+	input := []byte("not really an mp4")
+	tg.files["video1"] = input
+	fakeVideoProbe(t)
+
+	note := []byte("round note")
+	var transcoded []byte
+	var gotOpts videoOptions
+	a := newTestApp(t, tg, func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(int)) error {
+		data, err := os.ReadFile(src.Path)
+		if err != nil {
+			return err
+		}
+		transcoded, gotOpts = data, opts
+		onProgress(100)
+		return os.WriteFile(outputPath, note, 0o644)
+	})
+
+	a.handleVideo(context.Background(), videoMessage(42, "video1", len(input)))
+
+	if n := tg.called("getFile"); n != 1 {
+		t.Errorf("getFile called %d times, want 1", n)
+	}
+	if tg.downloads != 1 {
+		t.Errorf("file downloaded %d times, want 1", tg.downloads)
+	}
+	if string(transcoded) != string(input) {
+		t.Errorf("transcode got input %q, want the downloaded %q", transcoded, input)
+	}
+	if gotOpts.Size != a.cfg.VideoSize {
+		t.Errorf("transcode got size %d, want %d", gotOpts.Size, a.cfg.VideoSize)
+	}
+	if len(tg.notes) != 1 || string(tg.notes[0]) != string(note) {
+		t.Fatalf("sent notes %q, want one %q", tg.notes, note)
+	}
+	if !tg.sentText(localize("en", msgSending)) {
+		t.Errorf("no %q status message among %q", localize("en", msgSending), tg.texts)
+	}
+}
+
+func TestHandleVideoDownloadFailure(t *testing.T) {
+	tg := newFakeTelegram(t)
+	fakeVideoProbe(t)
+
+	a := newTestApp(t, tg, func(context.Context, videoSource, string, videoOptions, func(int)) error {
+		t.Error("transcode called for a failed download")
+		return nil
+	})
+
+	a.handleVideo(context.Background(), videoMessage(42, "missing", 100))
+
+	if len(tg.notes) != 0 {
+		t.Errorf("sent %d notes, want none", len(tg.notes))
+	}
+	if !tg.sentText(localize("en", msgDownloadFailed)) {
+		t.Errorf("no download failure message among %q", tg.texts)
+	}
+}
+
+func TestFileDownloadURL(t *testing.T) {
+	want := "https://api.telegram.org/file/bot123456:test-token/videos/file_1.mp4"
+	if got := fileDownloadURL(testToken, "videos/file_1.mp4"); got != want {
+		t.Errorf("fileDownloadURL() = %q, want %q", got, want)
+	}
+
+	want = "https://api.telegram.org/file/bot[redacted]/videos/file_1.mp4"
+	if got := redactedDownloadURL("videos/file_1.mp4"); got != want {
+		t.Errorf("redactedDownloadURL() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactURLError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := redactURLError("videos/file_1.mp4", &url.Error{Op: "Get", URL: fileDownloadURL(testToken, "videos/file_1.mp4"), Err: cause})
+	if strings.Contains(err.Error(), testToken) {
+		t.Errorf("redactURLError() = %q, contains the token", err)
+	}
+	if !strings.Contains(err.Error(), redactedDownloadURL("videos/file_1.mp4")) {
+		t.Errorf("redactURLError() = %q, want the redacted URL", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("redactURLError() = %q, doesn't wrap %q", err, cause)
+	}
+
+	other := errors.New("disk full")
+	if got := redactURLError("videos/file_1.mp4", other); got != other {
+		t.Errorf("redactURLError() = %v, want other errors unchanged", got)
+	}
+}
+
+// TestDownloadFailureLogsNoToken runs failed downloads through handleVideo
+// and checks the token never reaches the log.
+func TestDownloadFailureLogsNoToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// unreachable points downloads at a closed server, so the request
+		// itself fails with a *url.Error rather than a bad status.
+		unreachable bool
+	}{
+		{name: "bad status"},
+		{name: "connection refused", unreachable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newFakeTelegram(t)
+			fakeVideoProbe(t)
+			if tt.unreachable {
+				closed := httptest.NewServer(http.NotFoundHandler())
+				closed.Close()
+				fileEndpoint = closed.URL + "/file/bot%s/%s"
+			}
+
+			var logs bytes.Buffer
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+			t.Cleanup(func() { slog.SetDefault(old) })
+
+			a := newTestApp(t, tg, func(context.Context, videoSource, string, videoOptions, func(int)) error {
+				t.Error("transcode called for a failed download")
+				return nil
+			})
+			a.handleVideo(context.Background(), videoMessage(42, "missing", 100))
+
+			if !strings.Contains(logs.String(), "Error downloading file") {
+				t.Fatalf("download failure not logged:\n%s", logs.String())
+			}
+			if strings.Contains(logs.String(), testToken) {
+				t.Errorf("log contains the bot token:\n%s", logs.String())
+			}
+			if !strings.Contains(logs.String(), "[redacted]") {
+				t.Errorf("log has no redacted URL:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+// TestHandleVideoStatusSendFails checks that a conversion whose
+// "Processing" status message can't be sent still delivers the note.
+func TestHandleVideoStatusSendFails(t *testing.T) {
+	tg := newFakeTelegram(t)
+	tg.files["video1"] = []byte("video")
+	processing := localize("en", msgProcessing)
+	tg.rejectText = func(text string) bool { return strings.HasPrefix(text, processing) }
+	fakeVideoProbe(t)
+
+	a := newTestApp(t, tg, func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(int)) error {
+		onProgress(50)
+		return os.WriteFile(outputPath, []byte("note"), 0o644)
+	})
+
+	a.handleVideo(context.Background(), videoMessage(42, "video1", 5))
+
+	if len(tg.notes) != 1 {
+		t.Errorf("sent %d notes, want 1", len(tg.notes))
+	}
+	if n := tg.called("editMessageText"); n != 0 {
+		t.Errorf("editMessageText called %d times with no status message to edit", n)
+	}
+}
+
+// TestQuietModeConversion runs conversions in a quiet chat: the notes
+// arrive without any status messages around them.
+func TestQuietModeConversion(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []*tgbotapi.Message
+	}{
+		{"single video", []*tgbotapi.Message{videoMessage(42, "video1", 5)}},
+		{"album", []*tgbotapi.Message{videoMessage(42, "video1", 5), videoMessage(42, "video2", 5)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newFakeTelegram(t)
+			tg.files["video1"] = []byte("video")
+			tg.files["video2"] = []byte("video")
+			fakeVideoProbe(t)
+
+			a := newTestApp(t, tg, func(ctx context.Context, src videoSource, outputPath string, opts videoOptions, onProgress func(int)) error {
+				onProgress(50)
+				return os.WriteFile(outputPath, []byte("note"), 0o644)
+			})
+			a.cfg.QuietMode = true
+
+			if len(tt.messages) == 1 {
+				a.handleVideo(context.Background(), tt.messages[0])
+			} else {
+				a.handleAlbum(context.Background(), tt.messages)
+			}
+
+			if len(tg.notes) != len(tt.messages) {
+				t.Errorf("sent %d notes, want %d", len(tg.notes), len(tt.messages))
+			}
+			if len(tg.texts) != 0 {
+				t.Errorf("sent status messages %q in a quiet chat", tg.texts)
+			}
+		})
+	}
+}
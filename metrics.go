@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors. They live on a private registry
+// rather than the global default one, so constructing more than one (e.g.
+// in tests) never panics on duplicate registration.
+type metrics struct {
+	registry *prometheus.Registry
+
+	videosProcessed    *prometheus.CounterVec
+	processingDuration prometheus.Histogram
+	downloadBytes      prometheus.Counter
+	ffmpegFailures     prometheus.Counter
+	telegramErrors     *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		videosProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "circles_videos_processed_total",
+			Help: "Videos handled, by result.",
+		}, []string{"result"}),
+		processingDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "circles_processing_duration_seconds",
+			Help:    "Time spent in ffmpeg per video.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+		}),
+		downloadBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "circles_download_bytes_total",
+			Help: "Bytes downloaded from Telegram.",
+		}),
+		ffmpegFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "circles_ffmpeg_failures_total",
+			Help: "ffmpeg runs that exited with an error.",
+		}),
+		telegramErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "circles_telegram_api_errors_total",
+			Help: "Telegram API calls that failed after retries, by call.",
+		}, []string{"call"}),
+	}
+
+	m.registry.MustRegister(
+		m.videosProcessed,
+		m.processingDuration,
+		m.downloadBytes,
+		m.ffmpegFailures,
+		m.telegramErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// recordSuccess, recordFailure and recordDownload update both the /stats
+// counters and the Prometheus metrics.
+func (a *app) recordSuccess(processing time.Duration) {
+	a.stats.recordSuccess(processing)
+	a.metrics.videosProcessed.WithLabelValues("success").Inc()
+	a.metrics.processingDuration.Observe(processing.Seconds())
+}
+
+func (a *app) recordFailure() {
+	a.stats.recordFailure()
+	a.metrics.videosProcessed.WithLabelValues("failure").Inc()
+}
+
+func (a *app) recordDownload(bytes int64) {
+	a.stats.recordDownload(bytes)
+	a.metrics.downloadBytes.Add(float64(bytes))
+}
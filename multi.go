@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxMultiSizes caps how many notes one /multi request may produce.
+const maxMultiSizes = 4
+
+// defaultMultiSizes are made when /multi is given no sizes.
+var defaultMultiSizes = []int{384, maxVideoSize}
+
+// handleMulti converts the video replied to into one note per requested
+// size, downloading it only once.
+func (a *app) handleMulti(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	sizes, ok := parseMultiSizes(message.CommandArguments())
+	source := message.ReplyToMessage
+	if !ok || source == nil || !hasVideo(source) {
+		sendText(ctx, bot, chatID, localize(lang, msgMultiUsage, maxMultiSizes, minVideoSize, maxVideoSize, videoSizeStep), textError)
+		return
+	}
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	fileID, fileName := videoFile(source)
+	logger := requestLogger(chatID, "file_id", fileID, "sizes", sizes)
+	ctx = contextWithLogger(ctx, logger)
+
+	file, ok := a.getFile(ctx, fileID, chatID, lang)
+	if !ok {
+		return
+	}
+
+	ext := a.cfg.OutputFormat.Ext
+	inputPath, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, inputFileName(fileName, ""), ext)
+	if !a.download(ctx, file, inputPath, chatID, lang) {
+		return
+	}
+	sniffed, err := sniffInput(ctx, inputPath)
+	if err != nil {
+		logger.Info("Rejecting unreadable video", "err", err)
+		os.Remove(inputPath)
+		sendNotVideoMessage(ctx, bot, chatID, lang)
+		return
+	}
+	inputPath = sniffed
+	defer os.Remove(inputPath)
+
+	for _, size := range sizes {
+		if ctx.Err() != nil {
+			return
+		}
+		// convertAndSend removes each output once it is sent.
+		a.convertAndSend(ctx, conversion{
+			chatID:      chatID,
+			lang:        lang,
+			videoSize:   size,
+			isAnimation: source.Animation != nil,
+		}, inputPath, strings.TrimSuffix(outputPath, ext)+fmt.Sprintf("_%d", size)+ext)
+	}
+}
+
+// parseMultiSizes reads the diameters given to /multi, defaulting to
+// defaultMultiSizes. Duplicates are dropped; more than maxMultiSizes sizes
+// or an invalid one is an error.
+func parseMultiSizes(args string) ([]int, bool) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return defaultMultiSizes, true
+	}
+
+	var sizes []int
+	for _, field := range fields {
+		size, err := strconv.Atoi(field)
+		if err != nil || !validVideoSize(size) {
+			return nil, false
+		}
+		if !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes, len(sizes) <= maxMultiSizes
+}
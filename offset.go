@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidOffset = errors.New("invalid start offset")
+
+// parseClockTime parses SS, MM:SS or HH:MM:SS, where every part but the
+// first must be below 60.
+func parseClockTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, errInvalidOffset
+	}
+
+	var seconds int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, errInvalidOffset
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handlePreview replies to "/preview" sent in reply to a video with a
+// single round frame, so the user can check the framing before converting.
+func (a *app) handlePreview(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	source := message.ReplyToMessage
+	if source == nil || !hasVideo(source) {
+		sendText(ctx, bot, chatID, localize(lang, msgPreviewUsage), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	fileID, fileName := videoFile(source)
+	logger := requestLogger(chatID, "file_id", fileID)
+	ctx = contextWithLogger(ctx, logger)
+
+	file, ok := a.getFile(ctx, fileID, chatID, lang)
+	if !ok {
+		return
+	}
+
+	inputPath, previewPath := tempFilePaths(a.cfg.WorkDir, chatID, fileName, ".png")
+	if !a.download(ctx, file, inputPath, chatID, lang) {
+		return
+	}
+	defer os.Remove(inputPath)
+	defer os.Remove(previewPath)
+
+	if !a.acquireJobSlot(ctx, chatID, lang, true) {
+		return
+	}
+	opts := a.videoOptions(conversion{chatID: chatID, videoSize: a.videoSize(chatID)})
+	err := makePreviewFrame(ctx, inputPath, previewPath, opts, a.cfg.PreviewFeather)
+	a.releaseJobSlot()
+	if ctx.Err() != nil {
+		logger.Info("Preview cancelled")
+		return
+	}
+	if err != nil {
+		logger.Error("Error extracting preview frame", "err", err)
+		a.metrics.ffmpegFailures.Inc()
+		sendText(ctx, bot, chatID, localize(lang, msgProcessFailed), textError)
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FilePath(previewPath))
+	photo.Caption = localize(lang, msgPreviewCaption)
+	photo.ReplyToMessageID = source.MessageID
+	if _, err := bot.Send(photo); err != nil {
+		logger.Error("Error sending preview", "err", err)
+		sendText(ctx, bot, chatID, localize(lang, msgSendFailed), textError)
+	}
+}
+
+// videoFile returns the file ID and name of the video in message, checking
+// animations first since they also carry a Document.
+func videoFile(message *tgbotapi.Message) (fileID, fileName string) {
+	switch {
+	case message.Animation != nil:
+		return message.Animation.FileID, message.Animation.FileName
+	case message.Video != nil:
+		return message.Video.FileID, message.Video.FileName
+	case message.Document != nil:
+		return message.Document.FileID, message.Document.FileName
+	}
+	return "", ""
+}
+
+// makePreviewFrame writes one representative frame of inputPath to
+// outputPath as a PNG, framed as the note would be and masked to a circle
+// whose edge fades over feather pixels.
+func makePreviewFrame(ctx context.Context, inputPath, outputPath string, opts videoOptions, feather int) error {
+	out, err := exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-i", inputPath,
+		"-vf", "thumbnail,"+videoFilter(opts)+","+buildCircleMaskFilter(feather),
+		"-frames:v", "1",
+		"-y", outputPath,
+	).CombinedOutput()
+	if err != nil {
+		loggerFromContext(ctx).Debug("ffmpeg preview output", "output", string(out))
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpegPath and ffprobePath are the executables run for encoding and
+// probing, set from FFMPEG_PATH and FFPROBE_PATH at startup. Bare names are
+// looked up on PATH.
+var (
+	ffmpegPath  = "ffmpeg"
+	ffprobePath = "ffprobe"
+)
+
+// toolVersion checks that name is on PATH, or is an executable if it is a
+// path, and returns the first line of its -version output.
+func toolVersion(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s not found: %w", name, err)
+	}
+
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s -version: %w", path, err)
+	}
+
+	version, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(version), nil
+}
+
+// probeDuration returns the container duration reported by ffprobe.
+func probeDuration(ctx context.Context, path string) (time.Duration, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// probeHasVideo reports whether the file contains at least one video stream.
+func probeHasVideo(ctx context.Context, path string) (bool, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "v",
+		"-show_entries", "stream=codec_type",
+		"-of", "csv=p=0",
+		path,
+	).Output()
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+// probeResolution returns the frame size of the first video stream.
+func probeResolution(ctx context.Context, path string) (width, height int, err error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height",
+		"-of", "csv=s=x:p=0",
+		path,
+	).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "%dx%d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("parse resolution %q: %w", out, err)
+	}
+	return width, height, nil
+}
+
+// probeVideoFormat returns the codec and pixel format of the first video
+// stream, e.g. "h264" and "yuv420p".
+func probeVideoFormat(ctx context.Context, path string) (codec, pixFmt string, err error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,pix_fmt",
+		"-of", "csv=p=0",
+		path,
+	).Output()
+	if err != nil {
+		return "", "", err
+	}
+
+	codec, pixFmt, _ = strings.Cut(strings.TrimSpace(string(out)), ",")
+	return codec, pixFmt, nil
+}
+
+// probeFrameRate returns the frame rate of the first video stream in
+// frames per second, or 0 if ffprobe doesn't know it.
+func probeFrameRate(ctx context.Context, path string) (float64, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=r_frame_rate",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseFrameRate(strings.TrimSpace(string(out))), nil
+}
+
+// parseFrameRate reads ffprobe's rational frame rates such as
+// "30000/1001", returning 0 for "0/0" or anything unparseable.
+func parseFrameRate(s string) float64 {
+	num, den, found := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// probeRotation returns how many degrees clockwise the first video stream
+// must be turned to display upright, normalised to 0, 90, 180 or 270. Both
+// the legacy rotate tag and the display matrix side data are checked.
+func probeRotation(ctx context.Context, path string) (int, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream_tags=rotate:stream_side_data=rotation",
+		"-of", "default=noprint_wrappers=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseRotation(string(out)), nil
+}
+
+// parseRotation reads ffprobe's rotate tag (clockwise) or display matrix
+// rotation (counter-clockwise) from probeRotation's output.
+func parseRotation(out string) int {
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		degrees, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+
+		var clockwise int
+		switch key {
+		case "TAG:rotate":
+			clockwise = int(degrees)
+		case "rotation":
+			clockwise = -int(degrees)
+		default:
+			continue
+		}
+		// Normalise, then snap to the nearest quarter turn.
+		clockwise = (clockwise%360 + 360) % 360
+		return (clockwise + 45) / 90 * 90 % 360
+	}
+	return 0
+}
+
+// probeFormat returns ffprobe's comma-separated container format names,
+// e.g. "mov,mp4,m4a,3gp,3g2,mj2". It fails for files that aren't media.
+func probeFormat(ctx context.Context, path string) (string, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=format_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// probeAudioCodec returns the codec name of the first audio stream, or ""
+// if the file has no audio.
+func probeAudioCodec(ctx context.Context, path string) (string, error) {
+	out, err := exec.CommandContext(ctx,
+		ffprobePath,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want int
+	}{
+		{"no metadata", "", 0},
+		{"unrelated keys", "TAG:language=und\n", 0},
+		{"rotate tag", "TAG:rotate=90\n", 90},
+		{"rotate tag upside down", "TAG:rotate=180\n", 180},
+		{"display matrix counter-clockwise", "rotation=-90\n", 90},
+		{"display matrix clockwise", "rotation=90\n", 270},
+		{"display matrix zero", "rotation=0\n", 0},
+		{"full turn", "TAG:rotate=360\n", 0},
+		{"snapped to a quarter turn", "rotation=-89.5\n", 90},
+		{"side data section", "[SIDE_DATA]\nrotation=-270\n[/SIDE_DATA]\n", 270},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRotation(tt.out); got != tt.want {
+				t.Errorf("parseRotation(%q) = %d, want %d", tt.out, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const progressEditInterval = 3 * time.Second
+
+// logFFmpegOutput pumps ffmpeg's stderr into the log.
+func logFFmpegOutput(logger *slog.Logger, stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanFFmpegLines)
+	for scanner.Scan() {
+		logger.Debug("FFmpeg", "line", scanner.Text())
+	}
+
+	// Keep draining if the scanner gave up, e.g. on an overlong line, so
+	// ffmpeg never blocks writing to a full pipe.
+	io.Copy(io.Discard, stderr)
+}
+
+// scanFFmpegLines splits on both '\n' and '\r'; ffmpeg rewrites its status
+// line in place using carriage returns.
+func scanFFmpegLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// readFFmpegProgress reads the key=value blocks ffmpeg writes with
+// -progress and reports how much of an output total long has been written
+// to onProgress. A zero total or nil onProgress only drains r.
+func readFFmpegProgress(r io.Reader, total time.Duration, onProgress func(percent int)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if onProgress == nil || total <= 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// Despite its name out_time_ms is in microseconds too; it is
+			// all older ffmpeg versions write.
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				onProgress(progressPercent(time.Duration(us)*time.Microsecond, total))
+			}
+		case "progress":
+			if value == "end" {
+				onProgress(100)
+			}
+		}
+	}
+	io.Copy(io.Discard, r)
+}
+
+// expectedLength is the length of a note made from an input duration long
+// with at most max of it used, or 0 if duration is unknown.
+func expectedLength(duration, max time.Duration) time.Duration {
+	if duration <= 0 {
+		return 0
+	}
+	return min(duration, max)
+}
+
+func progressPercent(elapsed, total time.Duration) int {
+	if total <= 0 {
+		return 0
+	}
+	percent := int(elapsed * 100 / total)
+	if percent > 100 {
+		percent = 100
+	}
+	if percent < 0 {
+		percent = 0
+	}
+	return percent
+}
+
+// progressReporter edits a single status message with throttled updates.
+type progressReporter struct {
+	logger    *slog.Logger
+	bot       *telegramClient
+	chatID    int64
+	messageID int
+	text      string
+	last      time.Time
+	percent   int
+}
+
+// newProgressReporter edits message in chatID. message is the zero Message
+// when the status wasn't sent, because sending failed or the chat is quiet;
+// the reporter then has nothing to edit and stays silent.
+func newProgressReporter(logger *slog.Logger, bot *telegramClient, chatID int64, message tgbotapi.Message, text string) *progressReporter {
+	return &progressReporter{
+		logger:    logger,
+		bot:       bot,
+		chatID:    chatID,
+		messageID: message.MessageID,
+		text:      text,
+		last:      time.Now(),
+	}
+}
+
+// Restart switches to text for the next stage, resetting the percentage and
+// editing the message straight away.
+func (p *progressReporter) Restart(text string) {
+	p.text = text
+	p.percent = 0
+	p.last = time.Now()
+	if p.messageID == 0 {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(p.chatID, p.messageID, text)
+	if _, err := p.bot.Send(edit); err != nil {
+		p.logger.Warn("Error editing progress message", "err", err)
+	}
+}
+
+func (p *progressReporter) Report(percent int) {
+	if p.messageID == 0 || percent <= p.percent || time.Since(p.last) < progressEditInterval {
+		return
+	}
+	p.percent = percent
+	p.last = time.Now()
+
+	edit := tgbotapi.NewEditMessageText(p.chatID, p.messageID, fmt.Sprintf("%s %d%%", p.text, percent))
+	if _, err := p.bot.Send(edit); err != nil {
+		p.logger.Warn("Error editing progress message", "err", err)
+	}
+}
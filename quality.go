@@ -0,0 +1,40 @@
+package main
+
+// Quality levels selectable with /quality.
+const (
+	qualityLow    = "low"
+	qualityMedium = "medium"
+	qualityHigh   = "high"
+)
+
+// encoderSettings are the libx264 knobs a quality level maps to.
+type encoderSettings struct {
+	CRF    int
+	Preset string
+}
+
+// qualityLevels maps each level to libx264 settings. Lower CRF means higher
+// quality and larger files; 23 is libx264's own default.
+//
+//	low:    CRF 30, preset veryfast - smallest files, visible artefacts
+//	medium: CRF 23, preset medium   - libx264 defaults
+//	high:   CRF 18, preset slow     - near-transparent, largest files
+var qualityLevels = map[string]encoderSettings{
+	qualityLow:    {CRF: 30, Preset: "veryfast"},
+	qualityMedium: {CRF: 23, Preset: "medium"},
+	qualityHigh:   {CRF: 18, Preset: "slow"},
+}
+
+// x264Presets are libx264's presets from fastest to slowest.
+var x264Presets = []string{
+	"ultrafast", "superfast", "veryfast", "faster", "fast",
+	"medium", "slow", "slower", "veryslow",
+}
+
+// qualityOf returns the effective quality level for settings.
+func qualityOf(settings userSettings) string {
+	if _, ok := qualityLevels[settings.Quality]; ok {
+		return settings.Quality
+	}
+	return qualityMedium
+}
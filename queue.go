@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultWorkers bounds how many queued jobs are handled at once. Downloads
+// run in parallel up to this; ffmpeg itself is further limited by
+// MAX_CONCURRENT_JOBS.
+const defaultWorkers = 4
+
+// defaultMaxQueue bounds how many jobs may wait at once; past it new videos
+// are turned away straight away rather than left waiting for hours.
+const defaultMaxQueue = 100
+
+var errQueueFull = errors.New("job queue is full")
+
+type jobStatus string
+
+const (
+	jobQueued  jobStatus = "queued"
+	jobRunning jobStatus = "running"
+)
+
+// Job is one conversion waiting for or undergoing processing: an incoming
+// video, an album, or a command such as /multi. The original message is
+// kept so the job can be replayed after a restart.
+type Job struct {
+	ID         string            `json:"id"`
+	ChatID     int64             `json:"chat_id"`
+	FileID     string            `json:"file_id,omitempty"`
+	Status     jobStatus         `json:"status"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+	Message    *tgbotapi.Message `json:"message"`
+	// Album holds every item of a media group, in order; Message is the
+	// first of them.
+	Album []*tgbotapi.Message `json:"album,omitempty"`
+
+	// picked is a downloaded input whose owner chose a size. It only lives
+	// in memory, so after a restart the job is replayed from Message and
+	// the size is asked for again.
+	picked *pendingInput
+}
+
+// newJob returns a queued job for message.
+func newJob(message *tgbotapi.Message) *Job {
+	var fileID string
+	switch kind := messageInput(message); {
+	case kind == inputVideoNote:
+		fileID = message.VideoNote.FileID
+	case isVideoInput(kind):
+		fileID, _ = videoFile(message)
+	case kind == inputAudio:
+		fileID, _ = audioFile(message)
+	}
+	return &Job{
+		ID:         randomToken(),
+		ChatID:     message.Chat.ID,
+		FileID:     fileID,
+		Status:     jobQueued,
+		EnqueuedAt: time.Now(),
+		Message:    message,
+	}
+}
+
+// jobQueue is a FIFO of Jobs saved to a JSON file on every change, so work
+// that was queued or running when the process died is picked up again on
+// the next start.
+type jobQueue struct {
+	mu     sync.Mutex
+	path   string
+	limit  int
+	jobs   []*Job
+	notify chan struct{}
+}
+
+// loadJobQueue reads the queue stored at path, which accepts up to limit
+// jobs. Jobs that were running when the previous process stopped are
+// queued again, even past the limit.
+func loadJobQueue(path string, limit int) (*jobQueue, error) {
+	q := &jobQueue{path: path, limit: limit, notify: make(chan struct{}, 1)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range q.jobs {
+		job.Status = jobQueued
+	}
+	if len(q.jobs) > 0 {
+		q.signal()
+	}
+	return q, nil
+}
+
+// enqueue adds job and returns how many jobs are waiting ahead of it and
+// how many are running, or errQueueFull if as many jobs as the limit are
+// already waiting. The job is queued in memory even if saving fails.
+func (q *jobQueue) enqueue(job *Job) (ahead, running int, err error) {
+	q.mu.Lock()
+	for _, j := range q.jobs {
+		if j.Status == jobRunning {
+			running++
+		} else {
+			ahead++
+		}
+	}
+	if ahead >= q.limit {
+		q.mu.Unlock()
+		return ahead, running, errQueueFull
+	}
+	q.jobs = append(q.jobs, job)
+	err = q.save()
+	q.mu.Unlock()
+
+	q.signal()
+	return ahead, running, err
+}
+
+// dequeue blocks until a queued job is available and marks it running, or
+// returns nil once ctx is done.
+func (q *jobQueue) dequeue(ctx context.Context) (*Job, error) {
+	for {
+		q.mu.Lock()
+		for _, job := range q.jobs {
+			if job.Status == jobQueued {
+				job.Status = jobRunning
+				err := q.save()
+				q.mu.Unlock()
+				// Let the next worker look for more work.
+				q.signal()
+				return job, err
+			}
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// position finds the most recent job from chatID and reports how many
+// jobs are waiting ahead of it, how many jobs are running, and whether it
+// is running itself. ok is false if the chat has nothing queued.
+func (q *jobQueue) position(chatID int64) (ahead, busy int, running, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	last := -1
+	for i, job := range q.jobs {
+		if job.ChatID == chatID {
+			last = i
+		}
+		if job.Status == jobRunning {
+			busy++
+		}
+	}
+	if last < 0 {
+		return 0, busy, false, false
+	}
+
+	for _, job := range q.jobs[:last] {
+		if job.Status == jobQueued {
+			ahead++
+		}
+	}
+	return ahead, busy, q.jobs[last].Status == jobRunning, true
+}
+
+// complete removes a finished job.
+func (q *jobQueue) complete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.jobs {
+		if job.ID == id {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return q.save()
+		}
+	}
+	return nil
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the queue to disk. q.mu must be held.
+func (q *jobQueue) save() error {
+	data, err := json.Marshal(q.jobs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newTestQueue(t *testing.T, limit int) *jobQueue {
+	t.Helper()
+	q, err := loadJobQueue(filepath.Join(t.TempDir(), "jobs.json"), limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func testJob(chatID int64) *Job {
+	return newJob(&tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}})
+}
+
+func TestJobQueuePosition(t *testing.T) {
+	q := newTestQueue(t, 10)
+	for chatID := int64(1); chatID <= 4; chatID++ {
+		if _, _, err := q.enqueue(testJob(chatID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two workers take the first two jobs.
+	for i := 0; i < 2; i++ {
+		if _, err := q.dequeue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		chatID  int64
+		ahead   int
+		running bool
+		ok      bool
+	}{
+		{chatID: 1, running: true, ok: true},
+		{chatID: 2, running: true, ok: true},
+		{chatID: 3, ahead: 0, ok: true},
+		{chatID: 4, ahead: 1, ok: true},
+		{chatID: 5},
+	}
+	for _, tt := range tests {
+		ahead, busy, running, ok := q.position(tt.chatID)
+		if ahead != tt.ahead || running != tt.running || ok != tt.ok || busy != 2 {
+			t.Errorf("position(%d) = %d, %d, %t, %t; want %d, 2, %t, %t",
+				tt.chatID, ahead, busy, running, ok, tt.ahead, tt.running, tt.ok)
+		}
+	}
+}
+
+func TestJobQueueLimitCountsWaitingJobs(t *testing.T) {
+	q := newTestQueue(t, 2)
+	for chatID := int64(1); chatID <= 2; chatID++ {
+		if _, _, err := q.enqueue(testJob(chatID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := q.enqueue(testJob(3)); !errors.Is(err, errQueueFull) {
+		t.Fatalf("enqueue past the limit: err = %v, want errQueueFull", err)
+	}
+
+	// Once one starts running only one is waiting, so there is room again.
+	if _, err := q.dequeue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ahead, running, err := q.enqueue(testJob(3))
+	if err != nil {
+		t.Fatalf("enqueue with a running job: %v", err)
+	}
+	if ahead != 1 || running != 1 {
+		t.Errorf("enqueue() = %d ahead, %d running; want 1, 1", ahead, running)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a sliding-window limiter allowing limit events per window
+// for each chat. A zero limit disables it.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[int64][]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		window:    window,
+		hits:      make(map[int64][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow records an event for chatID if it is within the limit. Otherwise it
+// returns false and how long until the oldest event leaves the window.
+func (l *rateLimiter) allow(chatID int64) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > l.window {
+		l.sweep(now)
+	}
+
+	hits := l.recent(l.hits[chatID], now)
+	if len(hits) >= l.limit {
+		l.hits[chatID] = hits
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits[chatID] = append(hits, now)
+	return true, 0
+}
+
+// recent drops timestamps that have left the window.
+func (l *rateLimiter) recent(hits []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.window)
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
+
+// sweep forgets chats with no events in the current window so inactive users
+// don't accumulate.
+func (l *rateLimiter) sweep(now time.Time) {
+	for chatID, hits := range l.hits {
+		if len(l.recent(hits, now)) == 0 {
+			delete(l.hits, chatID)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// recoverRequest is deferred first in work serving a single request, i.e.
+// each queued job and each broadcast. A panic there is logged with its
+// stack and counted as a failure, and the user is told processing failed,
+// instead of the whole bot going down with it. The request's other
+// deferred cleanup still runs first.
+func (a *app) recoverRequest(ctx context.Context, chatID int64, lang string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	requestLogger(chatID).Error("Panic while handling request", "panic", r, "stack", string(debug.Stack()))
+	a.recordFailure()
+	sendText(ctx, a.bot, chatID, localize(lang, msgProcessFailed), textError)
+}
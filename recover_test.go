@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverRequest(t *testing.T) {
+	tg := newFakeTelegram(t)
+	a := newTestApp(t, tg, nil)
+
+	// Run in a goroutine of its own, as jobs are: a panic escaping there
+	// would take the whole test binary down.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer a.recoverRequest(context.Background(), 42, "en")
+		panic("boom")
+	}()
+	<-done
+
+	if !tg.sentText(localize("en", msgProcessFailed)) {
+		t.Errorf("no failure message among %q", tg.texts)
+	}
+	if a.stats.failed != 1 {
+		t.Errorf("failures recorded = %d, want 1", a.stats.failed)
+	}
+}
+
+func TestRunJobRecoversFromPanic(t *testing.T) {
+	tg := newFakeTelegram(t)
+	tg.files["video1"] = []byte("video")
+	fakeVideoProbe(t)
+
+	a := newTestApp(t, tg, func(context.Context, videoSource, string, videoOptions, func(int)) error {
+		panic("transcoder bug")
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.runJob(context.Background(), newJob(videoMessage(42, "video1", 5)))
+	}()
+	<-done
+
+	if len(tg.notes) != 0 {
+		t.Errorf("sent %d notes, want none", len(tg.notes))
+	}
+	// The job's own deferred cleanup runs before the panic is recovered;
+	// the input is kept for /convertlast.
+	if left, _ := filepath.Glob(filepath.Join(a.cfg.WorkDir, outputFilePrefix+"*")); len(left) != 0 {
+		t.Errorf("temp files left behind: %q", left)
+	}
+	if !tg.sentText(localize("en", msgProcessFailed)) {
+		t.Errorf("no failure message among %q", tg.texts)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// userSettings holds per-chat preferences. Zero values mean "use the
+// configured default".
+type userSettings struct {
+	VideoSize int `json:"video_size,omitempty"`
+	// Fit letterboxes the whole frame over a blurred background instead of
+	// cropping to the centre square.
+	Fit bool `json:"fit,omitempty"`
+	// Quality is one of the qualityLevels keys; empty means qualityMedium.
+	Quality string `json:"quality,omitempty"`
+	// Quiet suppresses progress messages; nil follows QUIET_MODE.
+	Quiet *bool `json:"quiet,omitempty"`
+	// Watermark is the file name, under DataDir/watermarks, of an image
+	// overlaid near the bottom of every note.
+	Watermark string `json:"watermark,omitempty"`
+}
+
+// SettingsStore keeps userSettings per chat. Implementations must be safe
+// for concurrent use.
+type SettingsStore interface {
+	Get(chatID int64) userSettings
+	Set(chatID int64, settings userSettings)
+}
+
+// Settings backends selected by SETTINGS_STORE.
+const (
+	settingsBackendMemory = "memory"
+	settingsBackendJSON   = "json"
+)
+
+// newSettingsBackend returns the store for backend, loading any settings
+// saved at path.
+func newSettingsBackend(backend, path string) (SettingsStore, error) {
+	if backend == settingsBackendMemory {
+		return newSettingsStore(), nil
+	}
+	return loadJSONSettingsStore(path)
+}
+
+// settingsStore is the in-memory SettingsStore; settings are lost on
+// restart.
+type settingsStore struct {
+	mu       sync.RWMutex
+	settings map[int64]userSettings
+}
+
+func newSettingsStore() *settingsStore {
+	return &settingsStore{settings: make(map[int64]userSettings)}
+}
+
+func (s *settingsStore) Get(chatID int64) userSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[chatID]
+}
+
+func (s *settingsStore) Set(chatID int64, settings userSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[chatID] = settings
+}
+
+// jsonSettingsStore is a settingsStore saved to a JSON file on every Set.
+type jsonSettingsStore struct {
+	*settingsStore
+	path string
+	// saveMu keeps writes in Set order so an older snapshot never
+	// replaces a newer one.
+	saveMu sync.Mutex
+}
+
+// loadJSONSettingsStore reads the settings stored at path; a missing file
+// is an empty store.
+func loadJSONSettingsStore(path string) (*jsonSettingsStore, error) {
+	s := &jsonSettingsStore{settingsStore: newSettingsStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.settings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jsonSettingsStore) Set(chatID int64, settings userSettings) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.settingsStore.Set(chatID, settings)
+
+	s.mu.RLock()
+	data, err := json.Marshal(s.settings)
+	s.mu.RUnlock()
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		slog.Error("Error saving settings", "path", s.path, "err", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const settingsResetCallback = "settings:reset"
+
+// handleSettings lists the chat's effective settings, marking those left
+// at their defaults, with a button to reset them all. "/settings reset"
+// does the same without the button.
+func (a *app) handleSettings(ctx context.Context, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "":
+	case "reset":
+		a.resetSettings(chatID)
+		sendText(ctx, a.bot, chatID, localize(lang, msgSettingsReset), textProgress)
+		return
+	default:
+		sendText(ctx, a.bot, chatID, localize(lang, msgSettingsUsage), textError)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, a.describeSettings(chatID, lang))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(localize(lang, msgSettingsResetButton), settingsResetCallback),
+	))
+	if _, err := a.bot.Send(msg); err != nil {
+		loggerFromContext(ctx).Error("Error sending settings", "err", err)
+	}
+}
+
+// handleSettingsCallback resets the settings of the chat the button was
+// pressed in and replaces the list with a confirmation.
+func (a *app) handleSettingsCallback(query *tgbotapi.CallbackQuery) {
+	lang := query.From.LanguageCode
+	if query.Message == nil {
+		a.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	chatID := query.Message.Chat.ID
+	a.resetSettings(chatID)
+	a.bot.Request(tgbotapi.NewCallback(query.ID, localize(lang, msgSettingsReset)))
+	// Editing the text without a reply markup drops the keyboard.
+	a.bot.Send(tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, localize(lang, msgSettingsReset)))
+}
+
+// resetSettings returns every setting of chatID to its default, deleting
+// the watermark image if there was one.
+func (a *app) resetSettings(chatID int64) {
+	if name := a.settings.Get(chatID).Watermark; name != "" {
+		os.Remove(a.watermarkPath(name))
+	}
+	a.settings.Set(chatID, userSettings{})
+}
+
+// describeSettings formats the effective settings of chatID, one per line.
+func (a *app) describeSettings(chatID int64, lang string) string {
+	settings := a.settings.Get(chatID)
+
+	onOff := func(on bool) string {
+		if on {
+			return localize(lang, msgSettingOn)
+		}
+		return localize(lang, msgSettingOff)
+	}
+	value := func(v string, isDefault bool) string {
+		if isDefault {
+			return localize(lang, msgSettingDefault, v)
+		}
+		return v
+	}
+
+	size := fmt.Sprintf("%d px", a.videoSize(chatID))
+	if settings.VideoSize == 0 && a.cfg.SizePicker {
+		size = localize(lang, msgSettingAskSize)
+	}
+
+	return localize(lang, msgSettings,
+		value(size, settings.VideoSize == 0),
+		value(onOff(settings.Fit), !settings.Fit),
+		value(qualityOf(settings), settings.Quality == ""),
+		value(onOff(a.isQuiet(chatID)), settings.Quiet == nil),
+		value(onOff(settings.Watermark != ""), settings.Watermark == ""),
+	)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jobCancelGrace is how long cancelled conversions get to clean up.
+const jobCancelGrace = 5 * time.Second
+
+// shutdown stops accepting updates, tells users with running conversions
+// that the bot is restarting and waits up to cfg.ShutdownTimeout for them
+// before cancelling whatever is left.
+func (a *app) shutdown(server *http.Server, cancelJobs context.CancelFunc) {
+	if a.cfg.Mode == modePolling {
+		a.bot.StopReceivingUpdates()
+	}
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), jobCancelGrace)
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Error shutting down HTTP server", "err", err)
+		}
+		cancel()
+	}
+
+	chats := a.active.chats()
+	for chatID, lang := range chats {
+		sendText(context.Background(), a.bot, chatID, localize(lang, msgRestarting), textProgress)
+	}
+
+	if len(chats) > 0 {
+		slog.Info("Waiting for in-flight conversions", "count", len(chats), "timeout", a.cfg.ShutdownTimeout)
+	}
+	if waitTimeout(&a.inflight, a.cfg.ShutdownTimeout) {
+		return
+	}
+
+	slog.Warn("Shutdown timeout reached, cancelling remaining conversions")
+	for chatID, lang := range a.active.chats() {
+		sendText(context.Background(), a.bot, chatID, localize(lang, msgRestartAborted), textError)
+	}
+	cancelJobs()
+	waitTimeout(&a.inflight, jobCancelGrace)
+}
+
+// waitTimeout waits for wg and reports whether it finished within timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
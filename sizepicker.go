@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// pickerTTL is how long a downloaded input waits for a size to be picked.
+// TempFileMaxAge is kept above it so the temp file sweeps never race it.
+const pickerTTL = 10 * time.Minute
+
+const sizeCallbackPrefix = "size:"
+
+// pickerSizes are the diameters offered as inline buttons.
+var pickerSizes = []int{240, 384, 512, 640}
+
+var (
+	errPickerExpired  = errors.New("size picker expired")
+	errPickerNotOwner = errors.New("size picked by another user")
+)
+
+// pendingInput is a downloaded video waiting for its owner to pick a size.
+type pendingInput struct {
+	conversion
+	// message is the video the input came from, for replaying the job
+	// after a restart.
+	message      *tgbotapi.Message
+	userID       int64
+	fileUniqueID string
+	inputPath    string
+	outputPath   string
+	expires      time.Time
+}
+
+// pendingInputs holds downloaded videos by callback token until a size is
+// picked. It owns their input files and deletes them on expiry.
+type pendingInputs struct {
+	mu    sync.Mutex
+	items map[string]pendingInput
+}
+
+func newPendingInputs() *pendingInputs {
+	return &pendingInputs{items: make(map[string]pendingInput)}
+}
+
+// add stores in and returns the token identifying it in callback data.
+func (p *pendingInputs) add(in pendingInput) string {
+	token := randomToken()
+	in.expires = time.Now().Add(pickerTTL)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sweep()
+	p.items[token] = in
+	return token
+}
+
+// take removes and returns the input for token if userID owns it. The
+// caller becomes responsible for its input file.
+func (p *pendingInputs) take(token string, userID int64) (pendingInput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	in, ok := p.items[token]
+	if !ok || time.Now().After(in.expires) {
+		return pendingInput{}, errPickerExpired
+	}
+	if in.userID != userID {
+		return pendingInput{}, errPickerNotOwner
+	}
+	delete(p.items, token)
+	return in, nil
+}
+
+// sweep drops expired inputs. p.mu must be held.
+func (p *pendingInputs) sweep() {
+	now := time.Now()
+	for token, in := range p.items {
+		if now.After(in.expires) {
+			os.Remove(in.inputPath)
+			delete(p.items, token)
+		}
+	}
+}
+
+func randomToken() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// usesSizePicker reports whether videos from chatID should offer size
+// buttons instead of converting straight away. Users who set a size with
+// /setsize skip the picker.
+func (a *app) usesSizePicker(chatID int64) bool {
+	return a.cfg.SizePicker && a.settings.Get(chatID).VideoSize == 0
+}
+
+// offerSizes parks the downloaded input and replies with one button per
+// size. It takes ownership of in.inputPath.
+func (a *app) offerSizes(ctx context.Context, in pendingInput) {
+	token := a.pending.add(in)
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, size := range pickerSizes {
+		if !validVideoSize(size) {
+			continue
+		}
+		data := fmt.Sprintf("%s%s:%d", sizeCallbackPrefix, token, size)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(size), data))
+	}
+
+	msg := tgbotapi.NewMessage(in.chatID, localize(in.lang, msgPickSize))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	if _, err := a.bot.Send(msg); err != nil {
+		loggerFromContext(ctx).Error("Error sending size picker", "err", err)
+		if in, err := a.pending.take(token, in.userID); err == nil {
+			os.Remove(in.inputPath)
+		}
+	}
+}
+
+// handleCallback queues a parked input for conversion at the size its owner
+// picked and removes the keyboard.
+func (a *app) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	if query.Data == settingsResetCallback {
+		a.handleSettingsCallback(query)
+		return
+	}
+
+	lang := query.From.LanguageCode
+	token, size, ok := parseSizeCallback(query.Data)
+	if !ok || query.Message == nil {
+		a.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	in, err := a.pending.take(token, query.From.ID)
+	switch {
+	case errors.Is(err, errPickerNotOwner):
+		a.bot.Request(tgbotapi.NewCallback(query.ID, localize(lang, msgPickerNotOwner)))
+		return
+	case err != nil:
+		a.bot.Request(tgbotapi.NewCallback(query.ID, localize(lang, msgPickerExpired)))
+		a.bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, localize(lang, msgPickerExpired)))
+		return
+	}
+
+	a.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+	// Editing the text without a reply markup drops the keyboard.
+	a.bot.Send(tgbotapi.NewEditMessageText(in.chatID, query.Message.MessageID, localize(in.lang, msgSizePicked, size)))
+
+	in.videoSize = size
+	job := newJob(in.message)
+	job.picked = &in
+	if !a.enqueueJob(ctx, job) {
+		os.Remove(in.inputPath)
+	}
+}
+
+// convertPicked converts a parked input at the size its owner picked, then
+// keeps the input for /convertlast.
+func (a *app) convertPicked(ctx context.Context, in pendingInput) {
+	defer a.lastInputs.put(in.chatID, lastInput{path: in.inputPath, fileUniqueID: in.fileUniqueID, isAnimation: in.isAnimation, duration: in.duration})
+
+	ctx, done := a.active.start(ctx, in.chatID, in.lang)
+	defer done()
+
+	logger := requestLogger(in.chatID, "size", in.videoSize)
+	ctx = contextWithLogger(ctx, logger)
+
+	c := in.conversion
+	c.cacheKey = a.cacheKey(in.fileUniqueID, c)
+	if a.sendCached(ctx, c) {
+		return
+	}
+	a.convertAndSend(ctx, c, in.inputPath, in.outputPath)
+}
+
+// parseSizeCallback splits "size:<token>:<diameter>" callback data.
+func parseSizeCallback(data string) (token string, size int, ok bool) {
+	rest, found := strings.CutPrefix(data, sizeCallbackPrefix)
+	if !found {
+		return "", 0, false
+	}
+	token, sizeText, found := strings.Cut(rest, ":")
+	if !found {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(sizeText)
+	if err != nil || !validVideoSize(size) {
+		return "", 0, false
+	}
+	return token, size, true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type stats struct {
+	mu              sync.Mutex
+	since           time.Time
+	processed       int
+	failed          int
+	processingTotal time.Duration
+	bytesDownloaded int64
+	// workUnits and workTime calibrate estimateProcessing.
+	workUnits float64
+	workTime  time.Duration
+}
+
+func newStats() *stats {
+	return &stats{since: time.Now()}
+}
+
+func (s *stats) recordDownload(bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesDownloaded += bytes
+}
+
+// recordSuccess counts a delivered note and the time ffmpeg spent on it.
+func (s *stats) recordSuccess(processing time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+	s.processingTotal += processing
+}
+
+// averageProcessing returns the mean ffmpeg time per delivered note, or 0
+// before the first one.
+func (s *stats) averageProcessing() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.processed == 0 {
+		return 0
+	}
+	return s.processingTotal / time.Duration(s.processed)
+}
+
+// recordWork adds a conversion of units that took took to the throughput
+// behind processing estimates.
+func (s *stats) recordWork(units float64, took time.Duration) {
+	if units <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workUnits += units
+	s.workTime += took
+}
+
+// secondsPerUnit returns the measured processing time per work unit, or
+// false before any conversion has been measured.
+func (s *stats) secondsPerUnit() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workUnits == 0 {
+		return 0, false
+	}
+	return s.workTime.Seconds() / s.workUnits, true
+}
+
+func (s *stats) recordFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed++
+}
+
+func (s *stats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.since = time.Now()
+	s.processed = 0
+	s.failed = 0
+	s.processingTotal = 0
+	s.bytesDownloaded = 0
+	s.workUnits = 0
+	s.workTime = 0
+}
+
+func (s *stats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var avg time.Duration
+	if s.processed > 0 {
+		avg = s.processingTotal / time.Duration(s.processed)
+	}
+
+	return fmt.Sprintf("Stats since %s\n"+
+		"Videos processed: %d\n"+
+		"Failures: %d\n"+
+		"Average processing time: %s\n"+
+		"Downloaded: %.1f MB",
+		s.since.Format(time.RFC3339), s.processed, s.failed,
+		avg.Round(100*time.Millisecond), float64(s.bytesDownloaded)/(1<<20))
+}
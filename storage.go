@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage keeps copies of processed notes under slash-separated keys.
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+}
+
+// uploadTimeout bounds a single archive upload.
+const uploadTimeout = 5 * time.Minute
+
+// Archive backends selected by ARCHIVE_STORAGE. Empty disables archiving.
+const (
+	storageBackendLocal = "local"
+	storageBackendS3    = "s3"
+)
+
+// newStorage returns the archive backend configured in cfg, or nil when
+// archiving is off.
+func newStorage(cfg config) (Storage, error) {
+	switch cfg.ArchiveBackend {
+	case storageBackendLocal:
+		if err := os.MkdirAll(cfg.ArchiveDir, 0o755); err != nil {
+			return nil, err
+		}
+		return localStorage{dir: cfg.ArchiveDir}, nil
+	case storageBackendS3:
+		if cfg.S3.Bucket == "" || cfg.S3.AccessKeyID == "" || cfg.S3.SecretAccessKey == "" {
+			return nil, errors.New("S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+		}
+		return &s3Storage{cfg: cfg.S3, client: &http.Client{Timeout: uploadTimeout}}, nil
+	}
+	return nil, nil
+}
+
+// localStorage saves files under dir, creating directories for keys as
+// needed.
+type localStorage struct {
+	dir string
+}
+
+func (s localStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// s3Config locates an S3-compatible bucket and the credentials to write
+// to it.
+type s3Config struct {
+	// Endpoint is the service URL, by default AWS's for Region. Buckets
+	// are addressed path-style, which MinIO and most other services
+	// accept.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// s3Storage uploads with plain PUT Object requests signed with AWS
+// Signature Version 4.
+type s3Storage struct {
+	cfg    s3Config
+	client *http.Client
+}
+
+func (s *s3Storage) Save(ctx context.Context, key string, r io.Reader) error {
+	// Notes are small, so the body is buffered to sign its hash.
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return err
+	}
+	u = u.JoinPath(s.cfg.Bucket, key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	s.sign(req, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: unexpected status %s: %s", key, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds the SigV4 Authorization header for a request with body, made
+// at now.
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.cfg.SecretAccessKey)
+	for _, part := range []string{date, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// archive saves a delivered note to the configured Storage, if any, under
+// the chat ID and the time it was made. Failures are only logged: the
+// user already has their note.
+func (a *app) archive(ctx context.Context, chatID int64, outputPath string) {
+	if a.storage == nil {
+		return
+	}
+	logger := loggerFromContext(ctx)
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		logger.Error("Error opening note for archiving", "err", err)
+		return
+	}
+	defer f.Close()
+
+	key := fmt.Sprintf("%d/%s%s", chatID, time.Now().UTC().Format("20060102T150405.000000000Z"), filepath.Ext(outputPath))
+	if err := a.storage.Save(ctx, key, f); err != nil {
+		logger.Error("Error archiving note", "key", key, "err", err)
+		return
+	}
+	logger.Info("Archived note", "key", key)
+}
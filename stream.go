@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// streamAndSend converts file by piping the download straight into ffmpeg.
+// It returns false, having told the user nothing, if ffmpeg couldn't handle
+// the piped input (e.g. an mp4 whose index sits at the end), so the caller
+// can retry from a seekable temp file.
+func (a *app) streamAndSend(ctx context.Context, c conversion, file tgbotapi.File, outputPath string) bool {
+	bot := a.bot
+	logger := loggerFromContext(ctx)
+
+	body, _, err := openDownload(ctx, bot, file, 0)
+	if err != nil {
+		logger.Warn("Error opening download stream", "err", err)
+		return false
+	}
+	defer body.Close()
+
+	// Without a file to probe, rely on Telegram's metadata and let ffmpeg
+	// drop the audio encoder if there's no audio track.
+	opts := a.videoOptions(c)
+	opts.Audio = audioEncode
+	opts.Length = expectedLength(c.duration, opts.MaxDuration)
+	if c.duration > opts.MaxDuration {
+		a.sendStatus(ctx, c.chatID, localize(c.lang, msgTrimmed, int(opts.MaxDuration.Seconds())))
+	}
+
+	cached := false
+	defer func() {
+		if !cached {
+			os.Remove(outputPath)
+		}
+	}()
+
+	logger.Info("Streaming video into ffmpeg")
+	src := &limitedReader{r: body, max: a.cfg.MaxFileSize}
+	processing, err := a.encode(ctx, c, videoSource{Reader: src}, outputPath, opts)
+	if ctx.Err() != nil {
+		logger.Info("Processing cancelled")
+		return true
+	}
+	if src.n > src.max {
+		logger.Info("Download exceeded size limit", "limit", src.max)
+		sendTooLargeMessage(ctx, bot, c.chatID, c.lang, src.max)
+		return true
+	}
+	if errors.Is(err, errFFmpegTimeout) {
+		a.reportTimeout(ctx, c, err)
+		return true
+	}
+	if err != nil {
+		logger.Warn("Error processing piped video", "err", err)
+		return false
+	}
+
+	// Re-encoding needs the input again, which only the temp file path has.
+	if info, err := os.Stat(outputPath); err != nil || info.Size() > a.cfg.MaxOutputSize {
+		logger.Info("Piped output too large or missing", "err", err)
+		return false
+	}
+
+	a.recordDownload(src.n)
+	cached = a.deliver(ctx, c, outputPath, processing)
+	return true
+}
+
+// limitedReader counts the bytes read from r and fails with
+// errFileTooLarge once more than max have been read.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n > l.max {
+		return 0, errFileTooLarge
+	}
+	if remaining := l.max + 1 - l.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max && (err == nil || errors.Is(err, io.EOF)) {
+		err = errFileTooLarge
+	}
+	return n, err
+}
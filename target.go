@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	errInvalidTargetOption = errors.New("invalid target option")
+	errTargetNotAllowed    = errors.New("bot or user may not post to target chat")
+
+	// chatUsernameRe matches public chat usernames as written in a
+	// target= option.
+	chatUsernameRe = regexp.MustCompile(`^@[a-z0-9_]{4,32}$`)
+)
+
+// parseTargetOption checks the value of a target= caption option: a
+// public username such as @channel or a numeric chat ID.
+func parseTargetOption(value string) (string, error) {
+	if chatUsernameRe.MatchString(value) {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value, nil
+	}
+	return "", errInvalidTargetOption
+}
+
+// resolveTarget returns the ID of the chat named by target if the bot can
+// post there and userID administers it. Requiring the user to be an admin
+// too stops anyone from posting into channels the bot happens to manage.
+func (a *app) resolveTarget(target string, userID int64) (int64, error) {
+	var ref tgbotapi.ChatConfig
+	if id, err := strconv.ParseInt(target, 10, 64); err == nil {
+		ref.ChatID = id
+	} else {
+		ref.SuperGroupUsername = target
+	}
+	chat, err := a.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: ref})
+	if err != nil {
+		return 0, err
+	}
+
+	member := func(id int64) (tgbotapi.ChatMember, error) {
+		return a.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+			ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: id},
+		})
+	}
+
+	self, err := member(a.bot.Self.ID)
+	if err != nil {
+		return 0, err
+	}
+	// Channel admins need the posting right; group admins can always post.
+	if !self.IsAdministrator() || (chat.IsChannel() && !self.CanPostMessages) {
+		return 0, errTargetNotAllowed
+	}
+
+	user, err := member(userID)
+	if err != nil {
+		return 0, err
+	}
+	if !user.IsCreator() && !user.IsAdministrator() {
+		return 0, errTargetNotAllowed
+	}
+	return chat.ID, nil
+}
+
+// applyTarget points c at the chat named by a target= option, telling the
+// user and leaving c replying to them if the note can't be posted there.
+func (a *app) applyTarget(ctx context.Context, c *conversion, target string, message *tgbotapi.Message) {
+	if target == "" {
+		return
+	}
+	logger := loggerFromContext(ctx)
+
+	err := errTargetNotAllowed
+	var id int64
+	if message.From != nil {
+		id, err = a.resolveTarget(target, message.From.ID)
+	}
+	if err != nil {
+		logger.Info("Can't post to target chat, replying instead", "target", target, "err", err)
+		sendText(ctx, a.bot, c.chatID, localize(c.lang, msgTargetUnavailable, target), textError)
+		return
+	}
+	logger.Info("Posting note to target chat", "target", target, "target_chat_id", id)
+	c.target = id
+}
+
+// destination is the chat c's note is sent to.
+func (c conversion) destination() int64 {
+	if c.target != 0 {
+		return c.target
+	}
+	return c.chatID
+}
+
+// confirmTargetPost tells the user their note went to the target chat,
+// since nothing else shows up where they sent the video.
+func (a *app) confirmTargetPost(ctx context.Context, c conversion) {
+	if c.target != 0 {
+		a.sendStatus(ctx, c.chatID, localize(c.lang, msgTargetPosted))
+	}
+}
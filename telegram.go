@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+// telegramClient wraps the bot API so every Send, Request, GetFile and
+// chat lookup call is retried on flood-control (429) and server-side (5xx) errors.
+type telegramClient struct {
+	*tgbotapi.BotAPI
+	retry   retryPolicy
+	metrics *metrics
+	// stop cuts waits between retries short once shutdown begins; nil
+	// waits them out.
+	stop context.Context
+}
+
+func (c *telegramClient) Send(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := withRetry(c.context(), c.retry, func() (tgbotapi.Message, error) {
+		return c.BotAPI.Send(chattable)
+	})
+	c.countError("send", err)
+	return msg, classifyTelegramError(err)
+}
+
+func (c *telegramClient) Request(chattable tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	resp, err := withRetry(c.context(), c.retry, func() (*tgbotapi.APIResponse, error) {
+		return c.BotAPI.Request(chattable)
+	})
+	c.countError("request", err)
+	return resp, classifyTelegramError(err)
+}
+
+func (c *telegramClient) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	file, err := withRetry(c.context(), c.retry, func() (tgbotapi.File, error) {
+		return c.BotAPI.GetFile(config)
+	})
+	c.countError("get_file", err)
+	return file, classifyTelegramError(err)
+}
+
+func (c *telegramClient) GetChat(config tgbotapi.ChatInfoConfig) (tgbotapi.Chat, error) {
+	chat, err := withRetry(c.context(), c.retry, func() (tgbotapi.Chat, error) {
+		return c.BotAPI.GetChat(config)
+	})
+	c.countError("request", err)
+	return chat, classifyTelegramError(err)
+}
+
+func (c *telegramClient) GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error) {
+	member, err := withRetry(c.context(), c.retry, func() (tgbotapi.ChatMember, error) {
+		return c.BotAPI.GetChatMember(config)
+	})
+	c.countError("request", err)
+	return member, classifyTelegramError(err)
+}
+
+// SetWebhook registers url for updates. WebhookConfig in this library
+// version has no secret_token field, so the call is made by hand.
+func (c *telegramClient) SetWebhook(url, secret string) error {
+	params := make(tgbotapi.Params)
+	params["url"] = url
+	params.AddNonEmpty("secret_token", secret)
+
+	_, err := withRetry(c.context(), c.retry, func() (*tgbotapi.APIResponse, error) {
+		return c.MakeRequest("setWebhook", params)
+	})
+	c.countError("request", err)
+	return err
+}
+
+// context returns c.stop, or a context that is never done if it is unset.
+func (c *telegramClient) context() context.Context {
+	if c.stop == nil {
+		return context.Background()
+	}
+	return c.stop
+}
+
+func (c *telegramClient) countError(call string, err error) {
+	if err != nil && c.metrics != nil {
+		c.metrics.telegramErrors.WithLabelValues(call).Inc()
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with a non-transient error,
+// policy.MaxAttempts is reached or ctx is done while waiting to retry.
+// Permanent errors such as VOICE_MESSAGES_FORBIDDEN are returned
+// immediately.
+func withRetry[T any](ctx context.Context, policy retryPolicy, fn func() (T, error)) (T, error) {
+	var result T
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		result, err = fn()
+		if err == nil || attempt >= policy.MaxAttempts {
+			return result, err
+		}
+
+		delay, ok := retryDelay(err, policy.BaseDelay, attempt)
+		if !ok {
+			return result, err
+		}
+
+		slog.Warn("Telegram API call failed, retrying", "err", err, "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay reports how long to wait before retrying err, honoring
+// retry_after on 429 and backing off exponentially on 5xx.
+func retryDelay(err error, base time.Duration, attempt int) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		if apiErr.RetryAfter > 0 {
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		}
+		return base << (attempt - 1), true
+	case apiErr.Code >= http.StatusInternalServerError:
+		return base << (attempt - 1), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram API failures the bot reacts to. telegramClient wraps matching
+// errors from the library so callers can test for them with errors.Is.
+var (
+	// errVoiceMessagesForbidden means the recipient's privacy settings
+	// block video and voice messages.
+	errVoiceMessagesForbidden = errors.New("voice messages forbidden")
+	// errRequestTooLarge means an upload exceeded Telegram's size limit.
+	errRequestTooLarge = errors.New("request too large")
+	// errFileTooBig is returned by getFile for files above the Bot API's
+	// 20 MB download limit.
+	errFileTooBig = errors.New("file too big to download")
+	// errChatUnreachable means the bot can never message the chat again:
+	// it was blocked, kicked, or the chat no longer exists.
+	errChatUnreachable = errors.New("chat unreachable")
+)
+
+// classifyTelegramError wraps err with the sentinel above that matches the
+// error code and description of a *tgbotapi.Error, and returns any other
+// error unchanged. Descriptions are compared case-insensitively, as their
+// wording is not part of the API.
+func classifyTelegramError(err error) error {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	description := strings.ToLower(apiErr.Message)
+	var sentinel error
+	switch {
+	case strings.Contains(description, "voice_messages_forbidden"):
+		sentinel = errVoiceMessagesForbidden
+	case apiErr.Code == http.StatusRequestEntityTooLarge, strings.Contains(description, "request entity too large"):
+		sentinel = errRequestTooLarge
+	case apiErr.Code == http.StatusBadRequest && strings.Contains(description, "file is too big"):
+		sentinel = errFileTooBig
+	case apiErr.Code == http.StatusForbidden, apiErr.Code == http.StatusBadRequest && strings.Contains(description, "chat not found"):
+		sentinel = errChatUnreachable
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
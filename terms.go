@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// showTermsOnce sends the operator's terms notice the first time a chat
+// talks to the bot. Chats that have seen it are saved, so a restart
+// doesn't show it again.
+func (a *app) showTermsOnce(ctx context.Context, message *tgbotapi.Message) {
+	if a.cfg.TermsNotice == "" {
+		return
+	}
+
+	added, err := a.termsSeen.add(message.Chat.ID)
+	if err != nil {
+		loggerFromContext(ctx).Error("Error saving terms notice list", "err", err)
+	}
+	if added {
+		sendText(ctx, a.bot, message.Chat.ID, truncateText(a.cfg.TermsNotice, maxMessageLength), textProgress)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// secretTokenHeader carries the secret_token Telegram was given in
+// setWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Startup retries for setWebhook, on top of the per-request retries in
+// telegramClient, so a cold start rides out Telegram or network blips.
+const (
+	webhookRegisterAttempts  = 6
+	webhookRegisterBaseDelay = 2 * time.Second
+)
+
+// webhookHandoffTimeout is how long a webhook request waits for room in the
+// update buffer, well within httpWriteTimeout.
+const webhookHandoffTimeout = 3 * time.Second
+
+const (
+	httpReadHeaderTimeout = 5 * time.Second
+	httpReadTimeout       = 10 * time.Second
+	httpWriteTimeout      = 10 * time.Second
+)
+
+// startUpdates returns the update channel for the configured BOT_MODE. In
+// webhook mode the handler is registered on mux.
+func startUpdates(bot *telegramClient, cfg config, mux *http.ServeMux) tgbotapi.UpdatesChannel {
+	if cfg.Mode == modeWebhook {
+		return startWebhook(bot, cfg, mux)
+	}
+	return startPolling(bot)
+}
+
+func startPolling(bot *telegramClient) tgbotapi.UpdatesChannel {
+	// getUpdates is refused while a webhook is registered, e.g. after
+	// switching a deployment from webhook mode back to polling.
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		slog.Error("Error deleting webhook", "err", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	slog.Info("Starting long polling")
+	return bot.GetUpdatesChan(u)
+}
+
+func startWebhook(bot *telegramClient, cfg config, mux *http.ServeMux) tgbotapi.UpdatesChannel {
+	if _, err := url.Parse(cfg.WebhookURL + cfg.WebhookPath); err != nil {
+		fatal("Invalid webhook URL", "err", err)
+	}
+
+	if err := registerWebhook(bot, cfg.WebhookURL+cfg.WebhookPath, cfg.WebhookSecret); err != nil {
+		fatal("Error setting webhook", "attempts", webhookRegisterAttempts, "err", err)
+	}
+
+	secret := &webhookSecret{}
+	secret.Store(cfg.WebhookSecret)
+	go reloadWebhookSecretOnHUP(bot, cfg, secret)
+
+	updates := make(chan tgbotapi.Update, bot.Buffer)
+	mux.HandleFunc(cfg.WebhookPath, requireSecretToken(secret, func(w http.ResponseWriter, r *http.Request) {
+		update, err := bot.HandleUpdate(r)
+		if err != nil {
+			slog.Warn("Invalid webhook request", "err", err, "remote_addr", r.RemoteAddr)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Only answer 200 once the update is accepted. If the main loop is
+		// backed up, a 503 makes Telegram deliver it again later instead of
+		// it being lost or the request timing out mid-handoff.
+		timer := time.NewTimer(webhookHandoffTimeout)
+		defer timer.Stop()
+		select {
+		case updates <- *update:
+		case <-timer.C:
+			slog.Warn("Update buffer full, asking Telegram to redeliver", "update_id", update.UpdateID)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	}))
+
+	slog.Info("Listening for webhook", "addr", cfg.ListenAddr, "path", cfg.WebhookPath)
+	return updates
+}
+
+// registerWebhook calls setWebhook until it succeeds, backing off
+// exponentially between attempts, and returns the last error if every
+// attempt fails.
+func registerWebhook(bot *telegramClient, url, secret string) error {
+	var err error
+	for attempt := 1; attempt <= webhookRegisterAttempts; attempt++ {
+		if err = bot.SetWebhook(url, secret); err == nil {
+			return nil
+		}
+		if attempt == webhookRegisterAttempts {
+			break
+		}
+		delay := webhookRegisterBaseDelay << (attempt - 1)
+		slog.Warn("Error setting webhook, retrying", "attempt", attempt, "retry_in", delay, "err", err)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// webhookSecret holds the current secret_token, which SIGHUP may replace
+// while requests are being checked against it.
+type webhookSecret struct {
+	value atomic.Pointer[string]
+}
+
+func (s *webhookSecret) Load() string {
+	if v := s.value.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+func (s *webhookSecret) Store(secret string) {
+	s.value.Store(&secret)
+}
+
+// reloadWebhookSecretOnHUP re-reads the webhook secret on every SIGHUP,
+// registers it with Telegram and only then starts requiring it, so updates
+// signed with the old secret are accepted until Telegram switches over.
+// Environment variables can't change under a running process, so in
+// practice rotation needs WEBHOOK_SECRET_FILE.
+func reloadWebhookSecretOnHUP(bot *telegramClient, cfg config, secret *webhookSecret) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		next, err := cfg.readWebhookSecret()
+		if err != nil {
+			slog.Error("Error reloading webhook secret, keeping the current one", "err", err)
+			continue
+		}
+		if next == secret.Load() {
+			slog.Info("Webhook secret unchanged")
+			continue
+		}
+		if err := registerWebhook(bot, cfg.WebhookURL+cfg.WebhookPath, next); err != nil {
+			slog.Error("Error registering new webhook secret, keeping the current one", "err", err)
+			continue
+		}
+		secret.Store(next)
+		slog.Info("Rotated webhook secret", "enabled", next != "")
+	}
+}
+
+// requireSecretToken rejects requests whose secret header doesn't match
+// the current secret with 403, so forged updates never reach next. While
+// no secret is configured every request is passed through.
+func requireSecretToken(secret *webhookSecret, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := secret.Load()
+		if want == "" {
+			next(w, r)
+			return
+		}
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			slog.Warn("Rejected webhook request with bad secret token",
+				"remote_addr", r.RemoteAddr, "token_present", token != "")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveHTTP starts serving mux, which carries the webhook handler in
+// webhook mode and the health check in both modes.
+func serveHTTP(addr string, mux *http.ServeMux) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "err", err)
+		}
+	}()
+	return server
+}
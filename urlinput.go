@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxURLRedirects = 5
+
+var (
+	errUnsafeHost        = errors.New("host resolves to a non-public address")
+	errNotVideoContent   = errors.New("content type is not a video")
+	carrierGradeNATRange = mustParseCIDR("100.64.0.0/10")
+)
+
+// urlClient fetches user-supplied links. Every connection, including those
+// made while following redirects, is checked at dial time so hostnames that
+// resolve to internal addresses are refused.
+var urlClient = &http.Client{
+	Timeout: downloadTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: denyNonPublicAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxURLRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// parseVideoURL returns the link in a message consisting of a single
+// http(s) URL, optionally alongside bot mentions as used in groups.
+func parseVideoURL(text string) (*url.URL, bool) {
+	var candidate string
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "@") {
+			continue
+		}
+		if candidate != "" {
+			return nil, false
+		}
+		candidate = field
+	}
+
+	u, err := url.Parse(candidate)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+func (a *app) handleURL(ctx context.Context, message *tgbotapi.Message, u *url.URL) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	logger := requestLogger(chatID, "url_host", u.Hostname())
+	ctx = contextWithLogger(ctx, logger)
+
+	fileName := path.Base(u.Path)
+	if fileName == "." || fileName == "/" {
+		fileName = "video"
+	}
+	fileName = inputFileName(fileName, "")
+
+	if !a.hasRoomForJob(ctx, chatID, lang) {
+		return
+	}
+
+	inputPath, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, fileName, a.cfg.OutputFormat.Ext)
+	logger.Info("Downloading video from URL", "path", inputPath)
+	downloaded, err := downloadURL(ctx, u, inputPath, a.cfg.MaxFileSize)
+	if ctx.Err() != nil {
+		logger.Info("Download cancelled")
+		return
+	}
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		logger.Info("Download exceeded size limit", "limit", a.cfg.MaxFileSize)
+		sendTooLargeMessage(ctx, bot, chatID, lang, a.cfg.MaxFileSize)
+		return
+	case errors.Is(err, errUnsafeHost):
+		logger.Warn("Refusing URL pointing at a non-public address", "err", err)
+		sendText(ctx, bot, chatID, localize(lang, msgURLUnsafe), textError)
+		return
+	case errors.Is(err, errNotVideoContent):
+		logger.Info("URL is not a video", "err", err)
+		sendNotVideoMessage(ctx, bot, chatID, lang)
+		return
+	case outOfStorage(err):
+		a.reportOutOfStorage(ctx, chatID, lang, err)
+		return
+	case err != nil:
+		logger.Error("Error downloading URL", "err", err)
+		a.recordFailure()
+		sendText(ctx, bot, chatID, localize(lang, msgURLFailed), textError)
+		return
+	}
+	a.recordDownload(downloaded)
+
+	// Confirm ffmpeg can read it before an encoding slot is spent on it, and
+	// let the content rather than the URL decide the extension.
+	sniffed, err := sniffInput(ctx, inputPath)
+	if err != nil {
+		logger.Info("Downloaded URL is not media", "err", err)
+		os.Remove(inputPath)
+		sendNotVideoMessage(ctx, bot, chatID, lang)
+		return
+	}
+	inputPath = sniffed
+	defer os.Remove(inputPath)
+
+	a.convertAndSend(ctx, conversion{
+		chatID:      chatID,
+		lang:        lang,
+		videoSize:   a.videoSize(chatID),
+		isAnimation: path.Ext(inputPath) == ".gif",
+	}, inputPath, outputPath)
+}
+
+// downloadURL fetches u into destPath, enforcing maxSize and a video
+// content type.
+func downloadURL(ctx context.Context, u *url.URL, destPath string, maxSize int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("download %s: unexpected status %s", u.Redacted(), resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return 0, errFileTooLarge
+	}
+
+	// Servers often label videos application/octet-stream, and error pages
+	// come back as 200 text/html, so the header alone decides nothing:
+	// the first bytes must look like media too.
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !videoMediaType(mediaType) && mediaType != "application/octet-stream" && mediaType != "" {
+		return 0, fmt.Errorf("%w: %q", errNotVideoContent, mediaType)
+	}
+
+	body := bufio.NewReaderSize(resp.Body, sniffLen)
+	head, err := body.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if sniffed := http.DetectContentType(head); !videoMediaType(sniffed) && sniffed != "application/octet-stream" {
+		return 0, fmt.Errorf("%w: content looks like %q", errNotVideoContent, sniffed)
+	}
+
+	return writeLimited(body, destPath, maxSize)
+}
+
+// sniffLen is how much of a response http.DetectContentType looks at.
+const sniffLen = 512
+
+func videoMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "video/") || mediaType == "image/gif"
+}
+
+// denyNonPublicAddress is a net.Dialer Control hook rejecting loopback,
+// private, link-local and other internal destinations.
+func denyNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", errUnsafeHost, host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		carrierGradeNATRange.Contains(ip))
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleVideoNote does the reverse of handleVideo: it letterboxes a round
+// note into a regular 16:9 video that can be saved and shared. The video is
+// always an H.264/AAC mp4, whatever OUTPUT_FORMAT says for notes, since
+// that is what plays everywhere it might be shared to.
+func (a *app) handleVideoNote(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+
+	if ok, wait := a.limiter.allow(chatID); !ok {
+		slog.Info("Rate limit exceeded", "chat_id", chatID, "retry_in", wait)
+		sendText(ctx, bot, chatID, localize(lang, msgRateLimited, int(wait.Seconds())+1), textError)
+		return
+	}
+
+	ctx, done := a.active.start(ctx, chatID, lang)
+	defer done()
+
+	note := message.VideoNote
+	logger := requestLogger(chatID, "file_id", note.FileID)
+	ctx = contextWithLogger(ctx, logger)
+
+	file, ok := a.getFile(ctx, note.FileID, chatID, lang)
+	if !ok {
+		return
+	}
+
+	format := outputFormats[formatMP4]
+	inputPath, outputPath := tempFilePaths(a.cfg.WorkDir, chatID, "note.mp4", format.Ext)
+	if !a.download(ctx, file, inputPath, chatID, lang) {
+		return
+	}
+	defer os.Remove(inputPath)
+	defer os.Remove(outputPath)
+
+	c := conversion{chatID: chatID, lang: lang}
+	opts := a.videoOptions(c)
+	opts.Letterbox = true
+	opts.Format = format
+	opts.Length = expectedLength(time.Duration(note.Duration)*time.Second, opts.MaxDuration)
+	opts.Audio = probeAudioMode(ctx, inputPath, opts.Format)
+
+	processing, err := a.encodeToFit(ctx, c, inputPath, outputPath, opts)
+	if ctx.Err() != nil {
+		logger.Info("Processing cancelled")
+		return
+	}
+	if errors.Is(err, errFFmpegTimeout) {
+		a.reportTimeout(ctx, c, err)
+		return
+	}
+	if errors.Is(err, errOutputTooLarge) {
+		a.reportOutputTooLarge(ctx, c, err)
+		return
+	}
+	if err != nil {
+		logger.Error("Error processing video note", "err", err)
+		a.reportFFmpegFailure(ctx, c, err)
+		return
+	}
+
+	a.sendStatus(ctx, chatID, localize(lang, msgSending))
+	if _, err := bot.Send(tgbotapi.NewVideo(chatID, tgbotapi.FilePath(outputPath))); err != nil {
+		logger.Error("Error sending video", "err", err)
+		a.recordFailure()
+		sendText(ctx, bot, chatID, localize(lang, msgSendFailed), textError)
+		return
+	}
+	a.recordSuccess(processing)
+}
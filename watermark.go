@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// watermarkMaxWidth bounds stored watermarks; they are scaled down to a
+// quarter of the note anyway.
+const watermarkMaxWidth = 256
+
+// watermarkPath returns where the watermark file name from userSettings
+// is stored.
+func (a *app) watermarkPath(name string) string {
+	return filepath.Join(a.cfg.DataDir, "watermarks", name)
+}
+
+// handleWatermark sets the image replied to as the chat's watermark, or
+// clears it with "/watermark off".
+func (a *app) handleWatermark(ctx context.Context, message *tgbotapi.Message) {
+	bot := a.bot
+	chatID := message.Chat.ID
+	lang := languageOf(message)
+	settings := a.settings.Get(chatID)
+
+	if strings.EqualFold(strings.TrimSpace(message.CommandArguments()), "off") {
+		if settings.Watermark != "" {
+			os.Remove(a.watermarkPath(settings.Watermark))
+			settings.Watermark = ""
+			a.settings.Set(chatID, settings)
+		}
+		sendText(ctx, bot, chatID, localize(lang, msgWatermarkOff), textProgress)
+		return
+	}
+
+	var fileID string
+	if message.ReplyToMessage != nil {
+		fileID = imageFile(message.ReplyToMessage)
+	}
+	if fileID == "" {
+		sendText(ctx, bot, chatID, localize(lang, msgWatermarkUsage), textError)
+		return
+	}
+
+	logger := requestLogger(chatID, "file_id", fileID)
+	ctx = contextWithLogger(ctx, logger)
+
+	file, ok := a.getFile(ctx, fileID, chatID, lang)
+	if !ok {
+		return
+	}
+	inputPath, _ := tempFilePaths(a.cfg.WorkDir, chatID, "watermark", ".png")
+	if !a.download(ctx, file, inputPath, chatID, lang) {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	name := fmt.Sprintf("%d-%s.png", chatID, randomToken())
+	if err := os.MkdirAll(filepath.Dir(a.watermarkPath(name)), 0o755); err != nil {
+		logger.Error("Error creating watermark directory", "err", err)
+		sendText(ctx, bot, chatID, localize(lang, msgProcessFailed), textError)
+		return
+	}
+	if err := makeWatermark(ctx, inputPath, a.watermarkPath(name)); err != nil {
+		logger.Info("Rejecting unusable watermark image", "err", err)
+		sendText(ctx, bot, chatID, localize(lang, msgWatermarkInvalid), textError)
+		return
+	}
+
+	// Re-read in case other settings changed while the image downloaded.
+	settings = a.settings.Get(chatID)
+	if settings.Watermark != "" {
+		os.Remove(a.watermarkPath(settings.Watermark))
+	}
+	settings.Watermark = name
+	a.settings.Set(chatID, settings)
+	sendText(ctx, bot, chatID, localize(lang, msgWatermarkSet), textProgress)
+}
+
+// imageFile returns the file ID of the largest size of message's photo,
+// or of an image sent as a document, or "" if there is neither.
+func imageFile(message *tgbotapi.Message) string {
+	if n := len(message.Photo); n > 0 {
+		return message.Photo[n-1].FileID
+	}
+	if doc := message.Document; doc != nil && strings.HasPrefix(doc.MimeType, "image/") {
+		return doc.FileID
+	}
+	return ""
+}
+
+// makeWatermark converts the image at inputPath to a PNG at most
+// watermarkMaxWidth wide, keeping any transparency. It fails for files
+// ffmpeg can't read as an image.
+func makeWatermark(ctx context.Context, inputPath, outputPath string) error {
+	out, err := exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-i", inputPath,
+		"-vf", fmt.Sprintf("scale='min(iw,%d)':-2,format=rgba", watermarkMaxWidth),
+		"-frames:v", "1",
+		"-y", outputPath,
+	).CombinedOutput()
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
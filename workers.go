@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// enqueueJob persists job for the worker pool, telling the user if it has
+// to wait behind others. It returns false if the queue is full.
+func (a *app) enqueueJob(ctx context.Context, job *Job) bool {
+	lang := languageOf(job.Message)
+	ahead, running, err := a.queue.enqueue(job)
+	if errors.Is(err, errQueueFull) {
+		slog.Warn("Job queue full, rejecting video", "chat_id", job.ChatID, "queued", ahead)
+		sendText(ctx, a.bot, job.ChatID, localize(lang, msgQueueFull), textError)
+		return false
+	}
+	if err != nil {
+		slog.Error("Error saving job queue", "err", err)
+	}
+	slog.Debug("Job enqueued", "chat_id", job.ChatID, "job_id", job.ID, "ahead", ahead, "running", running)
+
+	if ahead+running >= a.cfg.Workers {
+		a.sendStatus(ctx, job.ChatID, localize(lang, msgQueued))
+	}
+	return true
+}
+
+// enqueueAlbum queues the items of a media group as a single job.
+func (a *app) enqueueAlbum(ctx context.Context, messages []*tgbotapi.Message) {
+	job := newJob(messages[0])
+	job.Album = messages
+	a.enqueueJob(ctx, job)
+}
+
+// handlePosition tells the user where their latest video is in the queue
+// and, once there is history to go on, roughly how long it will take.
+func (a *app) handlePosition(ctx context.Context, message *tgbotapi.Message) {
+	lang := languageOf(message)
+	ahead, busy, running, ok := a.queue.position(message.Chat.ID)
+
+	var text string
+	switch {
+	case !ok:
+		text = localize(lang, msgPositionNone)
+	case running:
+		text = localize(lang, msgPositionRunning)
+	case ahead == 0:
+		text = localize(lang, msgPositionNext)
+	default:
+		text = localize(lang, msgPositionAhead, ahead)
+	}
+
+	if ok && !running {
+		if wait := estimateWait(ahead, busy, a.stats.averageProcessing(), min(a.cfg.Workers, a.cfg.MaxConcurrentJobs)); wait > 0 {
+			text += " " + localize(lang, msgPositionWait, wait)
+		}
+	}
+	sendText(ctx, a.bot, message.Chat.ID, text, textProgress)
+}
+
+// estimateWait guesses how long a job with ahead jobs waiting in front of
+// it and busy jobs running waits before finishing, given the average
+// processing time and how many jobs run in parallel. Running jobs count in
+// full, so the guess errs long. It returns 0 without an average to go on.
+func estimateWait(ahead, busy int, avg time.Duration, parallel int) time.Duration {
+	if avg <= 0 || parallel <= 0 {
+		return 0
+	}
+	rounds := (ahead+busy)/parallel + 1
+	return (avg * time.Duration(rounds)).Round(time.Second)
+}
+
+// startWorkers runs n workers taking jobs from the queue until stop is
+// done. Jobs themselves run under jobCtx, so a shutdown lets the current
+// jobs finish while no new ones are started.
+func (a *app) startWorkers(stop, jobCtx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go a.work(stop, jobCtx)
+	}
+}
+
+func (a *app) work(stop, jobCtx context.Context) {
+	for {
+		job, err := a.queue.dequeue(stop)
+		if err != nil {
+			slog.Error("Error saving job queue", "err", err)
+		}
+		if job == nil {
+			return
+		}
+
+		a.inflight.Add(1)
+		a.runJob(jobCtx, job)
+		a.inflight.Done()
+
+		// A job cut short by shutdown stays queued for the next start.
+		if jobCtx.Err() != nil {
+			return
+		}
+		if err := a.queue.complete(job.ID); err != nil {
+			slog.Error("Error saving job queue", "err", err)
+		}
+	}
+}
+
+// runJob hands a job to the handler for its album, picked size, command or
+// kind of input. A panic in the handler drops the job, so one bad input
+// can't take down the bot; whatever files it left behind go with the next
+// temp file sweep.
+func (a *app) runJob(ctx context.Context, job *Job) {
+	message := job.Message
+	defer a.recoverRequest(ctx, message.Chat.ID, languageOf(message))
+
+	switch {
+	case len(job.Album) > 0:
+		a.handleAlbum(ctx, job.Album)
+		return
+	case job.picked != nil:
+		a.convertPicked(ctx, *job.picked)
+		return
+	case message.IsCommand():
+		a.runCommand(ctx, message)
+		return
+	}
+
+	kind := messageInput(message)
+	logInputChoice(requestLogger(message.Chat.ID), message, kind)
+
+	switch kind {
+	case inputVideoNote:
+		a.handleVideoNote(ctx, message)
+	case inputAnimation, inputVideo, inputDocument:
+		a.handleVideo(ctx, message)
+	case inputAudio:
+		a.handleAudioNote(ctx, message)
+	case inputURL:
+		u, _ := parseVideoURL(message.Text)
+		a.handleURL(ctx, message, u)
+	}
+}
+
+// runCommand runs a command that converts or downloads something, which
+// handleCommand queued rather than running straight away.
+func (a *app) runCommand(ctx context.Context, message *tgbotapi.Message) {
+	switch message.Command() {
+	case "convertlast":
+		a.handleConvertLast(ctx, message)
+	case "multi":
+		a.handleMulti(ctx, message)
+	case "watermark":
+		a.handleWatermark(ctx, message)
+	case "preview":
+		a.handlePreview(ctx, message)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateWait(t *testing.T) {
+	tests := []struct {
+		ahead, busy, parallel int
+		avg                   time.Duration
+		want                  time.Duration
+	}{
+		{ahead: 0, busy: 0, parallel: 2, avg: 10 * time.Second, want: 10 * time.Second},
+		{ahead: 0, busy: 2, parallel: 2, avg: 10 * time.Second, want: 20 * time.Second},
+		{ahead: 3, busy: 2, parallel: 2, avg: 10 * time.Second, want: 30 * time.Second},
+		{ahead: 3, busy: 2, parallel: 2, avg: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := estimateWait(tt.ahead, tt.busy, tt.avg, tt.parallel); got != tt.want {
+			t.Errorf("estimateWait(%d, %d, %v, %d) = %v, want %v", tt.ahead, tt.busy, tt.avg, tt.parallel, got, tt.want)
+		}
+	}
+}